@@ -0,0 +1,353 @@
+package catalog
+
+import (
+	"avito/database"
+	"avito/models"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+type FieldChange struct {
+	ItemName string      `json:"item_name"`
+	SKU      string      `json:"sku,omitempty"`
+	Field    string      `json:"field"`
+	Old      interface{} `json:"old"`
+	New      interface{} `json:"new"`
+}
+
+type VariantsDiff struct {
+	Added   []string      `json:"added"`
+	Changed []FieldChange `json:"changed"`
+	Removed []string      `json:"removed"`
+}
+
+type Diff struct {
+	DryRun   bool          `json:"dry_run"`
+	Added    []string      `json:"added"`
+	Changed  []FieldChange `json:"changed"`
+	Removed  []string      `json:"removed"`
+	Variants VariantsDiff  `json:"variants"`
+}
+
+type syncedField struct {
+	column string
+	value  func(item models.Item) interface{}
+}
+
+type syncedVariantField struct {
+	column string
+	value  func(variant models.ItemVariant) interface{}
+}
+
+// syncedFields are the columns owned by the catalog file. Stock is not listed:
+// it changes with every purchase and is only taken from the file when an item
+// is added or restored.
+var syncedFields = []syncedField{
+	{"price", func(item models.Item) interface{} { return item.Price }},
+	{"per_user_limit", func(item models.Item) interface{} { return derefInt(item.PerUserLimit) }},
+	{"category", func(item models.Item) interface{} { return item.Category }},
+	{"tags", func(item models.Item) interface{} { return emptyToNil(item.Tags) }},
+	{"title", func(item models.Item) interface{} { return emptyToNil(item.Title) }},
+	{"description", func(item models.Item) interface{} { return emptyToNil(item.Description) }},
+	{"images", func(item models.Item) interface{} { return emptyToNil(item.Images) }},
+}
+
+var syncedVariantFields = []syncedVariantField{
+	{"size", func(variant models.ItemVariant) interface{} { return variant.Size }},
+	{"color", func(variant models.ItemVariant) interface{} { return variant.Color }},
+	{"price", func(variant models.ItemVariant) interface{} { return derefFloat(variant.Price) }},
+	{"is_default", func(variant models.ItemVariant) interface{} { return variant.Default }},
+}
+
+// emptyToNil makes empty and missing collections compare equal.
+func emptyToNil(value interface{}) interface{} {
+	if reflect.ValueOf(value).Len() == 0 {
+		return nil
+	}
+	return value
+}
+
+func derefInt(value *int) interface{} {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+func derefFloat(value *float32) interface{} {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+func changedFields(old models.Item, item models.Item) []FieldChange {
+	var changes []FieldChange
+	for _, field := range syncedFields {
+		oldValue, newValue := field.value(old), field.value(item)
+		if !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, FieldChange{ItemName: item.ItemName, Field: field.column, Old: oldValue, New: newValue})
+		}
+	}
+	return changes
+}
+
+func changedVariantFields(old catalogVariant, variant catalogVariant) []FieldChange {
+	var changes []FieldChange
+	if old.itemName != variant.itemName {
+		changes = append(changes, FieldChange{variant.itemName, variant.SKU, "item", old.itemName, variant.itemName})
+	}
+	for _, field := range syncedVariantFields {
+		oldValue, newValue := field.value(old.ItemVariant), field.value(variant.ItemVariant)
+		if !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, FieldChange{variant.itemName, variant.SKU, field.column, oldValue, newValue})
+		}
+	}
+	return changes
+}
+
+// catalogVariant is a variant together with the name of the item it belongs to.
+type catalogVariant struct {
+	models.ItemVariant
+	itemName string
+	deleted  bool
+}
+
+func variantsBySKU(items []models.Item) map[string]catalogVariant {
+	variants := make(map[string]catalogVariant)
+	for _, item := range items {
+		for _, variant := range item.Variants {
+			variants[variant.SKU] = catalogVariant{variant, item.ItemName, item.DeletedAt.Valid || variant.DeletedAt.Valid}
+		}
+	}
+	return variants
+}
+
+// syncMutex serializes syncs started by the file watcher and the admin endpoint.
+var syncMutex sync.Mutex
+
+func ReadItems(path string) ([]models.Item, error) {
+	content, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		return nil, readErr
+	}
+	var items []models.Item
+	if err := json.Unmarshal(content, &items); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(items))
+	seenSKU := make(map[string]bool)
+	for _, item := range items {
+		if item.ItemName == "" {
+			return nil, fmt.Errorf("item without item_name in %s", path)
+		}
+		if item.Price < 0 {
+			return nil, fmt.Errorf("item %s has negative price", item.ItemName)
+		}
+		if item.Stock != nil && *item.Stock < 0 {
+			return nil, fmt.Errorf("item %s has negative stock", item.ItemName)
+		}
+		if item.PerUserLimit != nil && *item.PerUserLimit <= 0 {
+			return nil, fmt.Errorf("item %s has non-positive per_user_limit", item.ItemName)
+		}
+		if seen[item.ItemName] {
+			return nil, fmt.Errorf("item %s is listed twice in %s", item.ItemName, path)
+		}
+		seen[item.ItemName] = true
+		defaults := 0
+		for _, variant := range item.Variants {
+			if variant.SKU == "" {
+				return nil, fmt.Errorf("variant of item %s without sku", item.ItemName)
+			}
+			if variant.Price != nil && *variant.Price < 0 {
+				return nil, fmt.Errorf("variant %s has negative price", variant.SKU)
+			}
+			if variant.Stock != nil && *variant.Stock < 0 {
+				return nil, fmt.Errorf("variant %s has negative stock", variant.SKU)
+			}
+			if seenSKU[variant.SKU] {
+				return nil, fmt.Errorf("sku %s is listed twice in %s", variant.SKU, path)
+			}
+			seenSKU[variant.SKU] = true
+			if variant.Default {
+				defaults++
+			}
+		}
+		if defaults > 1 {
+			return nil, fmt.Errorf("item %s has more than one default variant", item.ItemName)
+		}
+	}
+	return items, nil
+}
+
+// Plan compares items stored in the database (including soft-deleted ones
+// and their variants) with the desired catalog. Soft-deleted items and
+// variants that reappear count as added.
+func Plan(current []models.Item, desired []models.Item) Diff {
+	diff := Diff{
+		Added: []string{}, Changed: []FieldChange{}, Removed: []string{},
+		Variants: VariantsDiff{Added: []string{}, Changed: []FieldChange{}, Removed: []string{}},
+	}
+	stored := make(map[string]models.Item, len(current))
+	for _, item := range current {
+		stored[item.ItemName] = item
+	}
+	wanted := make(map[string]bool, len(desired))
+	for _, item := range desired {
+		wanted[item.ItemName] = true
+		old, ok := stored[item.ItemName]
+		if !ok || old.DeletedAt.Valid {
+			diff.Added = append(diff.Added, item.ItemName)
+			continue
+		}
+		diff.Changed = append(diff.Changed, changedFields(old, item)...)
+	}
+	for _, item := range current {
+		if !wanted[item.ItemName] && !item.DeletedAt.Valid {
+			diff.Removed = append(diff.Removed, item.ItemName)
+		}
+	}
+
+	storedVariants := variantsBySKU(current)
+	wantedVariants := variantsBySKU(desired)
+	for sku, variant := range wantedVariants {
+		old, ok := storedVariants[sku]
+		if !ok || old.deleted {
+			diff.Variants.Added = append(diff.Variants.Added, sku)
+			continue
+		}
+		diff.Variants.Changed = append(diff.Variants.Changed, changedVariantFields(old, variant)...)
+	}
+	for sku, variant := range storedVariants {
+		if _, ok := wantedVariants[sku]; !ok && !variant.deleted {
+			diff.Variants.Removed = append(diff.Variants.Removed, sku)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.SliceStable(diff.Changed, func(i, j int) bool { return diff.Changed[i].ItemName < diff.Changed[j].ItemName })
+	sort.Strings(diff.Variants.Added)
+	sort.Strings(diff.Variants.Removed)
+	sort.SliceStable(diff.Variants.Changed, func(i, j int) bool { return diff.Variants.Changed[i].SKU < diff.Variants.Changed[j].SKU })
+	return diff
+}
+
+func (diff Diff) Empty() bool {
+	return len(diff.Added) == 0 && len(diff.Changed) == 0 && len(diff.Removed) == 0 &&
+		len(diff.Variants.Added) == 0 && len(diff.Variants.Changed) == 0 && len(diff.Variants.Removed) == 0
+}
+
+func Sync(path string, dryRun bool) (Diff, error) {
+	syncMutex.Lock()
+	defer syncMutex.Unlock()
+
+	desired, err := ReadItems(path)
+	if err != nil {
+		return Diff{}, err
+	}
+
+	var diff Diff
+	err = database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		var current []models.Item
+		err := tx.Unscoped().Preload("Variants", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+			Find(&current).Error
+		if err != nil {
+			return err
+		}
+		diff = Plan(current, desired)
+		diff.DryRun = dryRun
+		if dryRun || diff.Empty() {
+			return nil
+		}
+		return apply(tx, current, desired, diff)
+	})
+	if err != nil {
+		return Diff{}, err
+	}
+	return diff, nil
+}
+
+func apply(tx *gorm.DB, current []models.Item, desired []models.Item, diff Diff) error {
+	stored := make(map[string]models.Item, len(current))
+	itemIDs := make(map[string]uint, len(current)+len(desired))
+	for _, item := range current {
+		stored[item.ItemName] = item
+		itemIDs[item.ItemName] = item.ID
+	}
+	for _, item := range desired {
+		old, ok := stored[item.ItemName]
+		if !ok {
+			newItem := item
+			newItem.Variants = nil
+			if err := tx.Create(&newItem).Error; err != nil {
+				return err
+			}
+			itemIDs[item.ItemName] = newItem.ID
+			continue
+		}
+		restored := old.DeletedAt.Valid
+		if !restored && len(changedFields(old, item)) == 0 {
+			continue
+		}
+		columns := make([]string, 0, len(syncedFields)+2)
+		for _, field := range syncedFields {
+			columns = append(columns, field.column)
+		}
+		if restored {
+			columns = append(columns, "deleted_at", "stock")
+		}
+		updated := item
+		updated.ID = old.ID
+		updated.Variants = nil
+		err := tx.Unscoped().Model(&updated).Select(columns).Updates(&updated).Error
+		if err != nil {
+			return err
+		}
+	}
+	if len(diff.Removed) > 0 {
+		if err := tx.Where("item_name IN ?", diff.Removed).Delete(&models.Item{}).Error; err != nil {
+			return err
+		}
+	}
+	return applyVariants(tx, variantsBySKU(current), variantsBySKU(desired), itemIDs, diff.Variants.Removed)
+}
+
+func applyVariants(tx *gorm.DB, stored, wanted map[string]catalogVariant, itemIDs map[string]uint, removed []string) error {
+	for sku, variant := range wanted {
+		old, ok := stored[sku]
+		updated := variant.ItemVariant
+		updated.ItemID = itemIDs[variant.itemName]
+		if !ok {
+			if err := tx.Create(&updated).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if !old.deleted && len(changedVariantFields(old, variant)) == 0 {
+			continue
+		}
+		columns := []string{"item_id"}
+		for _, field := range syncedVariantFields {
+			columns = append(columns, field.column)
+		}
+		if old.deleted {
+			columns = append(columns, "deleted_at", "stock")
+		}
+		updated.ID = old.ID
+		if err := tx.Unscoped().Model(&updated).Select(columns).Updates(&updated).Error; err != nil {
+			return err
+		}
+	}
+	if len(removed) > 0 {
+		if err := tx.Where("sku IN ?", removed).Delete(&models.ItemVariant{}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package catalog
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// Watch polls the catalog file and syncs the database whenever its
+// modification time changes. It never returns.
+func Watch(path string, interval time.Duration) {
+	var lastModified time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Printf("[catalog] could not stat %s: %v", path, err)
+			continue
+		}
+		if !info.ModTime().After(lastModified) {
+			continue
+		}
+		lastModified = info.ModTime()
+
+		diff, err := Sync(path, false)
+		if err != nil {
+			log.Printf("[catalog] sync failed: %v", err)
+			continue
+		}
+		log.Printf("[catalog] synced: added %v, changed %v, removed %v", diff.Added, diff.Changed, diff.Removed)
+	}
+}
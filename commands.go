@@ -0,0 +1,188 @@
+package main
+
+import (
+	"avito/catalog"
+	"avito/config"
+	"avito/database"
+	"avito/models"
+	"avito/scheduler"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gorm.io/gorm"
+	"os"
+	"sort"
+	"time"
+)
+
+type command struct {
+	help string
+	run  func(args []string) error
+}
+
+var commands = map[string]command{
+	"serve":          {"run the HTTP server (default)", func([]string) error { return serve() }},
+	"migrate":        {"migrate the database schema", func([]string) error { return MigrateDB() }},
+	"seed-items":     {"sync the catalog file into the database", seedItems},
+	"grant":          {"mint coins to a user: --user NAME --amount N [--memo TEXT]", grant},
+	"reset-password": {"set a new password: --user NAME [--password TEXT]", resetPassword},
+	"reconcile":      {"check balances against the ledger: [--freeze]", reconcile},
+	"verify-chain":   {"check the hash chain of transactions and purchases", verifyChain},
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags]\n\ncommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-15s %s\n", name, commands[name].help)
+	}
+}
+
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// auditCLI records an operator action taken from the command line.
+func auditCLI(db *gorm.DB, action string, target string) error {
+	return models.WriteAudit(db, &models.AuditLog{
+		Actor:   "cli",
+		Action:  "cli " + action,
+		Target:  target,
+		Outcome: models.AuditSuccess,
+	})
+}
+
+func seedItems(args []string) error {
+	flags := flag.NewFlagSet("seed-items", flag.ExitOnError)
+	path := flags.String("path", config.Cfg.Catalog.Path, "catalog file")
+	dryRun := flags.Bool("dry-run", false, "only print the changes")
+	flags.Parse(args)
+
+	diff, err := catalog.Sync(*path, *dryRun)
+	if err != nil {
+		return err
+	}
+	return printJSON(diff)
+}
+
+func grant(args []string) error {
+	flags := flag.NewFlagSet("grant", flag.ExitOnError)
+	username := flags.String("user", "", "receiver's username")
+	amount := flags.Float64("amount", 0, "coins to mint")
+	memo := flags.String("memo", "granted by operator", "transfer memo")
+	flags.Parse(args)
+
+	if *username == "" || *amount <= 0 {
+		flags.Usage()
+		return errors.New("--user and a positive --amount are required")
+	}
+	user, err := models.GetUserByUsername(*username)
+	if err != nil {
+		return err
+	}
+	if user.IsSystem() {
+		return errors.New("coins can not be granted to a system account")
+	}
+	var transaction models.Transaction
+	err = database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		transaction, err = models.Mint(tx, user.ID, float32(*amount), models.SanitizeMemo(*memo), models.CategoryGrant)
+		if err != nil {
+			return err
+		}
+		return auditCLI(tx, "grant", user.Username)
+	})
+	if err != nil {
+		return err
+	}
+	return printJSON(transaction)
+}
+
+func resetPassword(args []string) error {
+	flags := flag.NewFlagSet("reset-password", flag.ExitOnError)
+	username := flags.String("user", "", "username")
+	password := flags.String("password", "", "new password, generated when empty")
+	flags.Parse(args)
+
+	if *username == "" {
+		flags.Usage()
+		return errors.New("--user is required")
+	}
+	user, err := models.GetUserByUsername(*username)
+	if err != nil {
+		return err
+	}
+	if user.IsSystem() {
+		return errors.New("system accounts have no password")
+	}
+	generated := *password == ""
+	if generated {
+		random := make([]byte, 12)
+		if _, err := rand.Read(random); err != nil {
+			return err
+		}
+		*password = base64.RawURLEncoding.EncodeToString(random)
+	}
+	hashed, err := models.HashPassword(*password)
+	if err != nil {
+		return err
+	}
+	err = database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", hashed).Error; err != nil {
+			return err
+		}
+		return auditCLI(tx, "reset-password", user.Username)
+	})
+	if err != nil {
+		return err
+	}
+	if generated {
+		fmt.Println(*password)
+	}
+	return nil
+}
+
+func reconcile(args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	freeze := flags.Bool("freeze", false, "freeze users whose balance does not match the ledger")
+	flags.Parse(args)
+
+	run, err := scheduler.Reconcile(*freeze, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := printJSON(run); err != nil {
+		return err
+	}
+	if !run.Consistent() {
+		return fmt.Errorf("%d balances do not match the ledger, total is off by %v", len(run.Discrepancies), run.Imbalance)
+	}
+	return nil
+}
+
+func verifyChain(args []string) error {
+	flags := flag.NewFlagSet("verify-chain", flag.ExitOnError)
+	flags.Parse(args)
+
+	reports, err := models.VerifyChains(database.PostgresDB)
+	if err != nil {
+		return err
+	}
+	if err := printJSON(reports); err != nil {
+		return err
+	}
+	for _, report := range reports {
+		if !report.Intact() {
+			return fmt.Errorf("%s chain is broken at id %d: %s", report.Table, *report.BrokenID, report.Problem)
+		}
+	}
+	return nil
+}
@@ -1,14 +1,29 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
+	Server    ServerConfig
+	Database  DatabaseConfig
+	Catalog   CatalogConfig
+	Refund    RefundConfig
+	Payments  PaymentsConfig
+	Schedule  ScheduleConfig
+	Allowance AllowanceConfig
+	Reconcile ReconcileConfig
+	Events    EventsConfig
+	Webhooks  WebhooksConfig
+	Relay     RelayConfig
 }
 type ServerConfig struct {
 	SecretKey         string
 	Port              string
+	GRPCPort          string
 	ExpirationMinutes int
 }
 type DatabaseConfig struct {
@@ -18,13 +33,93 @@ type DatabaseConfig struct {
 	DatabaseName string
 	Port         string
 }
+type CatalogConfig struct {
+	Path          string
+	WatchInterval time.Duration
+	ImagesDir     string
+	ImagesBaseURL string
+}
+
+type RefundConfig struct {
+	Window time.Duration
+}
+
+type PaymentsConfig struct {
+	RequestTTL time.Duration
+}
+
+type ScheduleConfig struct {
+	Interval time.Duration
+}
+
+type AllowanceConfig struct {
+	Period     string
+	Amounts    map[string]float32
+	MaxBalance float32
+}
+
+type ReconcileConfig struct {
+	Interval time.Duration
+	Freeze   bool
+}
+
+type EventsConfig struct {
+	Heartbeat time.Duration
+	Buffer    int
+}
+
+type RelayConfig struct {
+	Sink     string
+	File     string
+	Brokers  string
+	Topic    string
+	Interval time.Duration
+}
+
+type WebhooksConfig struct {
+	Interval    time.Duration
+	Timeout     time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+}
 
 var Cfg = Config{}
 
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// getEnvAmounts parses a list like "user:100,admin:150".
+func getEnvAmounts(key string) map[string]float32 {
+	amounts := map[string]float32{}
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok {
+			continue
+		}
+		if amount, err := strconv.ParseFloat(value, 32); err == nil {
+			amounts[name] = float32(amount)
+		}
+	}
+	return amounts
+}
+
 func (config *Config) Init() {
 	config.Server = ServerConfig{
 		SecretKey:         os.Getenv("SECRET_KEY"),
 		Port:              os.Getenv("SERVER_PORT"),
+		GRPCPort:          getEnv("GRPC_PORT", "9090"),
 		ExpirationMinutes: 50,
 	}
 	config.Database = DatabaseConfig{
@@ -35,4 +130,45 @@ func (config *Config) Init() {
 		DatabaseName: os.Getenv("DATABASE_NAME"),
 		Port:         os.Getenv("DATABASE_PORT"),
 	}
+	config.Catalog = CatalogConfig{
+		Path:          getEnv("CATALOG_PATH", "data/items.json"),
+		WatchInterval: time.Duration(getEnvInt("CATALOG_WATCH_SECONDS", 10)) * time.Second,
+		ImagesDir:     getEnv("CATALOG_IMAGES_DIR", "data/images"),
+		ImagesBaseURL: getEnv("CATALOG_IMAGES_BASE_URL", "/api/images/"),
+	}
+	config.Refund = RefundConfig{
+		Window: time.Duration(getEnvInt("REFUND_WINDOW_HOURS", 14*24)) * time.Hour,
+	}
+	config.Payments = PaymentsConfig{
+		RequestTTL: time.Duration(getEnvInt("PAYMENT_REQUEST_TTL_HOURS", 72)) * time.Hour,
+	}
+	config.Schedule = ScheduleConfig{
+		Interval: time.Duration(getEnvInt("SCHEDULER_INTERVAL_SECONDS", 30)) * time.Second,
+	}
+	config.Allowance = AllowanceConfig{
+		Period:     getEnv("ALLOWANCE_PERIOD", "monthly"),
+		Amounts:    getEnvAmounts("ALLOWANCE_AMOUNTS"),
+		MaxBalance: float32(getEnvInt("ALLOWANCE_MAX_BALANCE", 0)),
+	}
+	config.Reconcile = ReconcileConfig{
+		Interval: time.Duration(getEnvInt("RECONCILE_INTERVAL_MINUTES", 60)) * time.Minute,
+		Freeze:   getEnv("RECONCILE_FREEZE", "false") == "true",
+	}
+	config.Events = EventsConfig{
+		Heartbeat: time.Duration(getEnvInt("EVENTS_HEARTBEAT_SECONDS", 15)) * time.Second,
+		Buffer:    getEnvInt("EVENTS_BUFFER", 32),
+	}
+	config.Webhooks = WebhooksConfig{
+		Interval:    time.Duration(getEnvInt("WEBHOOK_INTERVAL_SECONDS", 5)) * time.Second,
+		Timeout:     time.Duration(getEnvInt("WEBHOOK_TIMEOUT_SECONDS", 10)) * time.Second,
+		MaxAttempts: getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		Backoff:     time.Duration(getEnvInt("WEBHOOK_BACKOFF_SECONDS", 30)) * time.Second,
+	}
+	config.Relay = RelayConfig{
+		Sink:     getEnv("RELAY_SINK", "none"),
+		File:     getEnv("RELAY_FILE", "data/events.jsonl"),
+		Brokers:  getEnv("RELAY_BROKERS", "localhost:9092"),
+		Topic:    getEnv("RELAY_TOPIC", "shop-events"),
+		Interval: time.Duration(getEnvInt("RELAY_INTERVAL_SECONDS", 2)) * time.Second,
+	}
 }
@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"avito/database"
+	"avito/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const (
+	AuditTargetKey = "audit_target"
+	AuditActorKey  = "audit_actor"
+)
+
+// auditTarget names what the action was applied to for the audit log.
+func auditTarget(context *gin.Context, target string) {
+	context.Set(AuditTargetKey, target)
+}
+
+func ListAuditLogs(context *gin.Context) {
+	var query AuditQuery
+	var entries []models.AuditLog
+
+	if err := context.ShouldBindQuery(&query); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+	if query.Limit == 0 {
+		query.Limit = 100
+	}
+
+	db := database.PostgresDB.Model(&models.AuditLog{}).
+		Select("audit_logs.id, audit_logs.created_at, audit_logs.actor_id, " +
+			"coalesce(users.username, audit_logs.actor) as actor, audit_logs.action, audit_logs.target, " +
+			"audit_logs.request_id, audit_logs.ip, audit_logs.outcome, audit_logs.status, audit_logs.detail").
+		Joins("left join users on users.id = audit_logs.actor_id")
+	if query.Actor != "" {
+		db = db.Where("coalesce(users.username, audit_logs.actor) = ?", query.Actor)
+	}
+	if query.Action != "" {
+		db = db.Where("audit_logs.action LIKE ?", "%"+query.Action+"%")
+	}
+	if query.Target != "" {
+		db = db.Where("audit_logs.target = ?", query.Target)
+	}
+	if query.Outcome != "" {
+		db = db.Where("audit_logs.outcome = ?", query.Outcome)
+	}
+	if query.RequestID != "" {
+		db = db.Where("audit_logs.request_id = ?", query.RequestID)
+	}
+	if query.From != nil {
+		db = db.Where("audit_logs.created_at >= ?", *query.From)
+	}
+	if query.To != nil {
+		db = db.Where("audit_logs.created_at < ?", *query.To)
+	}
+	if err := db.Order("audit_logs.id desc").Limit(query.Limit).Offset(query.Offset).
+		Scan(&entries).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get audit log"})
+		context.Abort()
+		return
+	}
+	if entries == nil {
+		entries = []models.AuditLog{}
+	}
+	context.JSON(http.StatusOK, entries)
+}
@@ -4,7 +4,6 @@ import (
 	"avito/models"
 	"avito/token"
 	"errors"
-	"fmt"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"net/http"
@@ -18,45 +17,69 @@ func Auth(context *gin.Context) {
 		return
 	}
 
-	user, getError := models.GetUserByUsername(userData.Username)
-	fmt.Println("dfghj")
-	fmt.Println(user, getError)
+	context.Set(AuditActorKey, userData.Username)
+	auditTarget(context, userData.Username)
+
+	user, status, message := login(userData)
+	if status != http.StatusOK {
+		context.JSON(status, ErrorResponse{Error: message})
+		context.Abort()
+		return
+	}
+
+	context.Set("user_id", user.ID)
+	signedToken, err := token.GenerateToken(user)
+
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Error generating tokens"})
+		context.Abort()
+		return
+	}
+
+	tokenResponse := TokenResponse{
+		SignedToken: signedToken}
+
+	context.JSON(http.StatusOK, tokenResponse)
+}
+
+// login returns the user with the given credentials and registers a new one
+// when the username is free. It is shared by every transport.
+func login(credentials models.User) (models.User, int, string) {
+	user, getError := models.GetUserByUsername(credentials.Username)
 
 	if getError != nil {
 		if !errors.Is(getError, gorm.ErrRecordNotFound) {
-			context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not make search result"})
-			context.Abort()
-			return
+			return user, http.StatusInternalServerError, "Could not make search result"
 		}
-		user = userData
+		user = credentials
 		if hashedPassword, err := models.HashPassword(user.Password); err == nil {
 			user.Password = hashedPassword
 		} else {
-			context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not hash password"})
-			context.Abort()
-			return
+			return user, http.StatusInternalServerError, "Could not hash password"
 		}
 		if err := user.CreateUser(); err != nil {
-			context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Could not create user"})
-			context.Abort()
-			return
-		}
-	} else {
-		if !user.ValidatePassword(userData.Password) {
-			context.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Incorrect password"})
-			context.Abort()
-			return
+			return user, http.StatusBadRequest, "Could not create user"
 		}
+	} else if user.IsSystem() || !user.ValidatePassword(credentials.Password) {
+		return user, http.StatusUnauthorized, "Incorrect password"
 	}
+	return user, http.StatusOK, ""
+}
 
-	signedToken, err := token.GenerateToken(user)
-
+// authorizedUser loads the user set by middleware.Authenticate and answers
+// 401 itself when there is none.
+func authorizedUser(context *gin.Context) (models.User, bool) {
+	userId, ok := context.Get("user_id")
+	if !ok {
+		context.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Authorization failed"})
+		context.Abort()
+		return models.User{}, false
+	}
+	user, err := models.GetUserByID(userId)
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Error generating tokens"})
+		context.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Authorization failed"})
+		context.Abort()
+		return models.User{}, false
 	}
-
-	tokenResponse := TokenResponse{
-		SignedToken: signedToken}
-
-	context.JSON(http.StatusOK, tokenResponse)
+	return user, true
 }
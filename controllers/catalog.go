@@ -0,0 +1,26 @@
+package controllers
+
+import (
+	"avito/catalog"
+	"avito/config"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+func SyncCatalog(context *gin.Context) {
+	dryRun, err := strconv.ParseBool(context.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Incorrect dry_run value"})
+		context.Abort()
+		return
+	}
+
+	diff, err := catalog.Sync(config.Cfg.Catalog.Path, dryRun)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not sync catalog"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, diff)
+}
@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// respondWithETag writes body as JSON with a strong ETag computed from its
+// content and answers 304 when the client already has the same representation.
+func respondWithETag(context *gin.Context, body interface{}) {
+	content, err := json.Marshal(body)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+	sum := sha256.Sum256(content)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	context.Header("ETag", etag)
+	context.Header("Cache-Control", "no-cache")
+	for _, candidate := range strings.Split(context.GetHeader("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			context.AbortWithStatus(http.StatusNotModified)
+			return
+		}
+	}
+	context.Data(http.StatusOK, "application/json; charset=utf-8", content)
+}
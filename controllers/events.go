@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"avito/config"
+	"avito/events"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"time"
+)
+
+// StreamEvents pushes the user's events as Server-Sent Events until the
+// client disconnects. The event name is the event type. When the token the
+// stream was opened with expires, an expired event ends the stream.
+func StreamEvents(context *gin.Context) {
+	userId, ok := context.Get("user_id")
+	if !ok {
+		context.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Authorization failed"})
+		context.Abort()
+		return
+	}
+	subscription := events.Subscribe(userId.(uint))
+	defer subscription.Close()
+
+	interval := config.Cfg.Events.Heartbeat
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+	var expired <-chan time.Time
+	if expiresAt := context.GetTime("token_expires_at"); !expiresAt.IsZero() {
+		expiry := time.NewTimer(time.Until(expiresAt))
+		defer expiry.Stop()
+		expired = expiry.C
+	}
+
+	context.Header("Cache-Control", "no-cache")
+	context.Header("X-Accel-Buffering", "no")
+	context.SSEvent("ready", gin.H{"user_id": userId})
+	context.Writer.Flush()
+	context.Stream(func(writer io.Writer) bool {
+		select {
+		case event := <-subscription.Events:
+			context.SSEvent(event.Type, event)
+			return true
+		case <-heartbeat.C:
+			_, err := io.WriteString(writer, ": ping\n\n")
+			return err == nil
+		case <-expired:
+			context.SSEvent("expired", gin.H{"error": "token is expired"})
+			return false
+		case <-context.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"avito/database"
+	"avito/models"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+func CreateGroupRequest(context *gin.Context) {
+	var payload GroupRequestPayload
+	var participants []models.User
+
+	if err := context.ShouldBindJSON(&payload); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+
+	usernames := make([]string, len(payload.Shares))
+	amounts := make([]float32, len(payload.Shares))
+	for i, share := range payload.Shares {
+		usernames[i], amounts[i] = share.User, share.Amount
+	}
+	if err := database.PostgresDB.Where("username IN ?", usernames).Find(&participants).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not create group request"})
+		context.Abort()
+		return
+	}
+	byName := make(map[string]models.User, len(participants))
+	for _, participant := range participants {
+		byName[participant.Username] = participant
+	}
+	seen := make(map[string]bool, len(usernames))
+	for _, username := range usernames {
+		participant, found := byName[username]
+		if !found || participant.ID == user.ID || participant.IsSystem() || seen[username] {
+			context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Incorrect participant's username: " + username})
+			context.Abort()
+			return
+		}
+		seen[username] = true
+	}
+	amounts, err := models.SplitShares(payload.Total, amounts)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Shares do not add up to the total"})
+		context.Abort()
+		return
+	}
+
+	if payload.Category == "" {
+		payload.Category = models.CategoryOther
+	}
+	request := models.GroupRequest{
+		RequesterID: user.ID,
+		Total:       payload.Total,
+		Memo:        models.SanitizeMemo(payload.Memo),
+		Category:    payload.Category,
+		Status:      models.GroupRequestOpen,
+	}
+	for i, username := range usernames {
+		request.Shares = append(request.Shares, models.GroupShare{
+			PayerID: byName[username].ID,
+			Amount:  amounts[i],
+		})
+	}
+	if err := database.PostgresDB.Create(&request).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not create group request"})
+		context.Abort()
+		return
+	}
+	request.Requester = user
+	for i, username := range usernames {
+		request.Shares[i].Payer = byName[username]
+	}
+	context.JSON(http.StatusCreated, newGroupRequestSchema(request))
+}
+
+func ListGroupRequests(context *gin.Context) {
+	var requests []models.GroupRequest
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+
+	db := database.PostgresDB.Preload("Requester").
+		Preload("Shares", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).Preload("Shares.Payer").
+		Where("requester_id = ? OR id IN (?)", user.ID,
+			database.PostgresDB.Model(models.GroupShare{}).Select("group_request_id").Where("payer_id = ?", user.ID))
+	if status := context.Query("status"); status != "" {
+		db = db.Where("status = ?", status)
+	}
+	if err := db.Order("created_at desc").Find(&requests).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get group requests"})
+		context.Abort()
+		return
+	}
+	schemas := make([]GroupRequestSchema, 0, len(requests))
+	for _, request := range requests {
+		schemas = append(schemas, newGroupRequestSchema(request))
+	}
+	context.JSON(http.StatusOK, schemas)
+}
+
+// findGroupRequest loads the request with the names newGroupRequestSchema
+// shows.
+func findGroupRequest(db *gorm.DB, id interface{}) (models.GroupRequest, error) {
+	var request models.GroupRequest
+	err := db.Preload("Requester").
+		Preload("Shares", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).Preload("Shares.Payer").
+		Where("id = ?", id).First(&request).Error
+	return request, err
+}
+
+func GetGroupRequest(context *gin.Context) {
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+
+	request, err := findGroupRequest(database.PostgresDB, context.Param("id"))
+	if err == nil && !request.Involves(user.ID) {
+		err = gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find group request"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, newGroupRequestSchema(request))
+}
+
+func PayGroupRequest(context *gin.Context) {
+	var request models.GroupRequest
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+
+	err := database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if request, err = models.LockGroupRequest(tx, context.Param("id")); err != nil {
+			return err
+		}
+		if _, err = request.Pay(tx, user.ID, time.Now()); err != nil {
+			return err
+		}
+		request, err = findGroupRequest(tx, request.ID)
+		return err
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find group request"})
+	case errors.Is(err, models.ErrGroupRequestClosed):
+		context.JSON(http.StatusConflict, ErrorResponse{Error: "Group request is already closed"})
+	case errors.Is(err, models.ErrShareAlreadyPaid):
+		context.JSON(http.StatusConflict, ErrorResponse{Error: "Share is already paid"})
+	case errors.Is(err, models.ErrAccountFrozen):
+		context.JSON(http.StatusForbidden, ErrorResponse{Error: "Account is frozen"})
+	case errors.Is(err, models.ErrInsufficientFunds):
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Insufficient funds to complete the transaction"})
+	case err != nil:
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not pay group request"})
+	default:
+		context.JSON(http.StatusOK, newGroupRequestSchema(request))
+		return
+	}
+	context.Abort()
+}
+
+func CancelGroupRequest(context *gin.Context) {
+	var request models.GroupRequest
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+
+	err := database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if request, err = models.LockGroupRequest(tx, context.Param("id")); err != nil {
+			return err
+		}
+		if request.RequesterID != user.ID {
+			return gorm.ErrRecordNotFound
+		}
+		if err = request.Cancel(tx); err != nil {
+			return err
+		}
+		request, err = findGroupRequest(tx, request.ID)
+		return err
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find group request"})
+	case errors.Is(err, models.ErrGroupRequestClosed):
+		context.JSON(http.StatusConflict, ErrorResponse{Error: "Group request is already closed"})
+	case errors.Is(err, models.ErrGroupRequestHasPaid):
+		context.JSON(http.StatusConflict, ErrorResponse{Error: "Group request already has paid shares"})
+	case err != nil:
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not cancel group request"})
+	default:
+		context.JSON(http.StatusOK, newGroupRequestSchema(request))
+		return
+	}
+	context.Abort()
+}
+
+func newGroupRequestSchema(request models.GroupRequest) GroupRequestSchema {
+	schema := GroupRequestSchema{
+		ID:        request.ID,
+		Requester: request.Requester.Username,
+		Total:     request.Total,
+		Collected: request.Collected,
+		Memo:      request.Memo,
+		Category:  request.Category,
+		Status:    request.Status,
+		CreatedAt: request.CreatedAt,
+		FundedAt:  request.FundedAt,
+		Shares:    make([]GroupShareSchema, 0, len(request.Shares)),
+	}
+	for _, share := range request.Shares {
+		schema.Shares = append(schema.Shares, GroupShareSchema{
+			User:          share.Payer.Username,
+			Amount:        share.Amount,
+			PaidAt:        share.PaidAt,
+			TransactionID: share.TransactionID,
+		})
+	}
+	return schema
+}
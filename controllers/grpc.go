@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"avito/database"
+	"avito/models"
+	"avito/shoppb"
+	"avito/token"
+	"context"
+	"github.com/gin-gonic/gin/binding"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ShopService serves the gRPC API with the same logic as the HTTP handlers.
+// Failures keep the HTTP error messages and are mapped to gRPC codes.
+type ShopService struct {
+	shoppb.UnimplementedShopServiceServer
+}
+
+type rpcUserKey struct{}
+
+// WithRPCUser stores the id of the authenticated caller for the service.
+func WithRPCUser(ctx context.Context, userID uint) context.Context {
+	return context.WithValue(ctx, rpcUserKey{}, userID)
+}
+
+func RPCUser(ctx context.Context) (uint, bool) {
+	userID, ok := ctx.Value(rpcUserKey{}).(uint)
+	return userID, ok
+}
+
+func rpcError(httpStatus int, message string) error {
+	code := codes.FailedPrecondition
+	switch {
+	case httpStatus == http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case httpStatus == http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case httpStatus == http.StatusForbidden:
+		code = codes.PermissionDenied
+	case httpStatus == http.StatusNotFound:
+		code = codes.NotFound
+	case httpStatus == http.StatusConflict:
+		code = codes.Aborted
+	case httpStatus >= http.StatusInternalServerError:
+		code = codes.Internal
+	}
+	return status.Error(code, message)
+}
+
+func rpcUser(ctx context.Context) (models.User, error) {
+	var user models.User
+	userID, ok := RPCUser(ctx)
+	if !ok {
+		return user, rpcError(http.StatusUnauthorized, "Authorization failed")
+	}
+	if err := database.PostgresDB.Where("ID = ?", userID).First(&user).Error; err != nil {
+		return user, rpcError(http.StatusUnauthorized, "Authorization failed")
+	}
+	return user, nil
+}
+
+func (ShopService) Auth(ctx context.Context, request *shoppb.AuthRequest) (*shoppb.AuthResponse, error) {
+	credentials := models.User{Username: request.GetUsername(), Password: request.GetPassword()}
+	if err := binding.Validator.ValidateStruct(credentials); err != nil {
+		return nil, rpcError(http.StatusBadRequest, "Does not bind schema")
+	}
+	user, httpStatus, message := login(credentials)
+	if httpStatus != http.StatusOK {
+		return nil, rpcError(httpStatus, message)
+	}
+	signedToken, err := token.GenerateToken(user)
+	if err != nil {
+		return nil, rpcError(http.StatusInternalServerError, "Error generating tokens")
+	}
+	return &shoppb.AuthResponse{Token: signedToken}, nil
+}
+
+func (ShopService) GetInfo(ctx context.Context, request *shoppb.GetInfoRequest) (*shoppb.InfoResponse, error) {
+	user, err := rpcUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groupBy := request.GetGroupBy()
+	if groupBy == "" {
+		groupBy = "item"
+	}
+	info, httpStatus, message := userInfo(&user, groupBy, request.GetCategory())
+	if httpStatus != http.StatusOK {
+		return nil, rpcError(httpStatus, message)
+	}
+
+	response := shoppb.InfoResponse{Coins: info.Coins, CoinHistory: &shoppb.CoinHistory{}}
+	for _, entry := range info.Inventory {
+		response.Inventory = append(response.Inventory, &shoppb.InventoryItem{
+			Type: entry.Type, Sku: entry.SKU, Size: entry.Size, Color: entry.Color, Quantity: entry.Quantity})
+	}
+	for _, entry := range info.CoinHistory.Received {
+		response.CoinHistory.Received = append(response.CoinHistory.Received, &shoppb.ReceivedTransfer{
+			FromUser: entry.FromUser, Amount: entry.Amount, Memo: entry.Memo, Category: entry.Category, Reversal: entry.Reversal})
+	}
+	for _, entry := range info.CoinHistory.Sent {
+		response.CoinHistory.Sent = append(response.CoinHistory.Sent, &shoppb.SentTransfer{
+			ToUser: entry.ToUser, Amount: entry.Amount, Memo: entry.Memo, Category: entry.Category, Reversal: entry.Reversal})
+	}
+	return &response, nil
+}
+
+func (ShopService) SendCoin(ctx context.Context, request *shoppb.SendCoinRequest) (*shoppb.SendCoinResponse, error) {
+	payload := SendToPayload{ToUser: request.GetToUser(), Amount: request.GetAmount(), Memo: request.GetMemo(),
+		Category: request.GetCategory()}
+	if err := binding.Validator.ValidateStruct(payload); err != nil {
+		return nil, rpcError(http.StatusBadRequest, err.Error())
+	}
+	user, err := rpcUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if httpStatus, message := sendCoins(&user, payload); httpStatus != http.StatusOK {
+		return nil, rpcError(httpStatus, message)
+	}
+	return &shoppb.SendCoinResponse{}, nil
+}
+
+func (ShopService) BuyItem(ctx context.Context, request *shoppb.BuyItemRequest) (*shoppb.BuyItemResponse, error) {
+	user, err := rpcUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	options := BuyOptions{SKU: request.GetSku(), Size: request.GetSize(), Color: request.GetColor(), Promo: request.GetPromo()}
+	if httpStatus, message := buyItem(&user, request.GetItem(), options); httpStatus != http.StatusOK {
+		return nil, rpcError(httpStatus, message)
+	}
+	return &shoppb.BuyItemResponse{}, nil
+}
+
+func (ShopService) ListItems(ctx context.Context, request *shoppb.ListItemsRequest) (*shoppb.ListItemsResponse, error) {
+	query := ItemsQuery{
+		MinPrice:  request.MinPrice,
+		MaxPrice:  request.MaxPrice,
+		Available: request.Available,
+		Category:  request.GetCategory(),
+		Tag:       request.GetTag(),
+		Lang:      request.GetLang(),
+		Sort:      request.GetSort(),
+		Order:     request.GetOrder(),
+		Limit:     int(request.GetLimit()),
+		Offset:    int(request.GetOffset()),
+	}
+	if err := binding.Validator.ValidateStruct(query); err != nil {
+		return nil, rpcError(http.StatusBadRequest, err.Error())
+	}
+	locale := models.DefaultLocale
+	if query.Lang != "" {
+		locale = strings.ToLower(query.Lang)
+	}
+	items, httpStatus, message := listItems(query, locale)
+	if httpStatus != http.StatusOK {
+		return nil, rpcError(httpStatus, message)
+	}
+
+	response := shoppb.ListItemsResponse{Total: items.Total, Limit: int32(items.Limit), Offset: int32(items.Offset)}
+	for _, item := range items.Items {
+		response.Items = append(response.Items, newItemMessage(item))
+	}
+	return &response, nil
+}
+
+func newItemMessage(item ItemSchema) *shoppb.Item {
+	message := shoppb.Item{
+		Name:         item.Name,
+		Title:        item.Title,
+		Description:  item.Description,
+		Category:     item.Category,
+		Tags:         item.Tags,
+		Images:       item.Images,
+		Price:        item.Price,
+		RegularPrice: item.RegularPrice,
+		Available:    item.Available,
+		Stock:        optionalInt32(item.Stock),
+		PerUserLimit: optionalInt32(item.PerUserLimit),
+		UpdatedAt:    timestamppb.New(item.UpdatedAt),
+	}
+	if item.Sale != nil {
+		message.Sale = &shoppb.Sale{Name: item.Sale.Name, EndsAt: optionalTimestamp(item.Sale.EndsAt)}
+	}
+	for _, variant := range item.Variants {
+		message.Variants = append(message.Variants, &shoppb.Variant{
+			Sku:          variant.SKU,
+			Size:         variant.Size,
+			Color:        variant.Color,
+			Price:        variant.Price,
+			RegularPrice: variant.RegularPrice,
+			Available:    variant.Available,
+			Stock:        optionalInt32(variant.Stock),
+		})
+	}
+	return &message
+}
+
+func optionalInt32(value *int) *int32 {
+	if value == nil {
+		return nil
+	}
+	converted := int32(*value)
+	return &converted
+}
+
+func optionalTimestamp(value *time.Time) *timestamppb.Timestamp {
+	if value == nil {
+		return nil
+	}
+	return timestamppb.New(*value)
+}
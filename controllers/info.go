@@ -4,14 +4,12 @@ import (
 	"avito/database"
 	"avito/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"net/http"
 )
 
 func GetInfo(context *gin.Context) {
 	var user models.User
-	var inventory []InventorySchema
-	var received []ReceivedSchema
-	var sent []SentSchema
 
 	if userId, ok := context.Get("user_id"); !ok {
 		context.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Authorization failed"})
@@ -23,43 +21,81 @@ func GetInfo(context *gin.Context) {
 		return
 	}
 
-	err := database.PostgresDB.Model(models.Purchase{}).
-		Select("items.item_name as type, count(purchases.id) as quantity").
-		Joins("left join items on items.id = purchases.item_id").
-		Where("purchases.user_id = ?", user.ID).
-		Group("items.item_name").Scan(&inventory).Error
-	if err != nil {
-		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+	info, status, message := userInfo(&user, context.DefaultQuery("group_by", "item"), context.Query("category"))
+	if status != http.StatusOK {
+		context.JSON(status, ErrorResponse{Error: message})
 		context.Abort()
 		return
 	}
+	context.JSON(http.StatusOK, info)
+}
+
+// userInfo collects the balance, inventory and coin history of the user. It
+// is shared by every transport.
+func userInfo(user *models.User, groupBy string, category string) (InfoSchema, int, string) {
+	var inventory []InventorySchema
+	var received []ReceivedSchema
+	var sent []SentSchema
+	var err error
+
+	switch groupBy {
+	case "item":
+		err = database.PostgresDB.Model(models.Purchase{}).
+			Select("items.item_name as type, count(purchases.id) as quantity").
+			Joins("left join items on items.id = purchases.item_id").
+			Where("purchases.user_id = ? AND purchases.returned_at IS NULL", user.ID).
+			Group("items.item_name").Scan(&inventory).Error
+	case "variant":
+		err = database.PostgresDB.Model(models.Purchase{}).
+			Select("items.item_name as type, coalesce(item_variants.sku, '') as sku, coalesce(item_variants.size, '') as size, "+
+				"coalesce(item_variants.color, '') as color, count(purchases.id) as quantity").
+			Joins("left join items on items.id = purchases.item_id").
+			Joins("left join item_variants on item_variants.id = purchases.variant_id").
+			Where("purchases.user_id = ? AND purchases.returned_at IS NULL", user.ID).
+			Group("items.item_name, item_variants.sku, item_variants.size, item_variants.color").
+			Order("items.item_name, item_variants.sku").Scan(&inventory).Error
+	default:
+		return InfoSchema{}, http.StatusBadRequest, "Incorrect group_by value"
+	}
+	if err != nil {
+		return InfoSchema{}, http.StatusInternalServerError, err.Error()
+	}
+
+	if category != "" && !models.IsTransferCategory(category) {
+		return InfoSchema{}, http.StatusBadRequest, "Incorrect category value"
+	}
+	// system accounts issue and take coins outside of transfers between
+	// users, those movements are left to the statement
+	history := func(db *gorm.DB) *gorm.DB {
+		db = db.Where("coalesce(users.role, '') <> ?", models.RoleSystem)
+		if category != "" {
+			db = db.Where("transactions.category = ?", category)
+		}
+		return db.Order("transactions.created_at")
+	}
 
 	err = database.PostgresDB.Model(models.Transaction{}).
-		Select("users.username as from_user, amount as amount").
+		Select("users.username as from_user, amount as amount, transactions.memo, transactions.category, "+
+			"transactions.reversal_of_id is not null as reversal").
 		Joins("left join users on users.id = transactions.sender_id").
 		Where("transactions.receiver_id = ?", user.ID).
-		Order("transactions.created_at").Scan(&received).Error
+		Scopes(history).Scan(&received).Error
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
-		context.Abort()
-		return
+		return InfoSchema{}, http.StatusInternalServerError, err.Error()
 	}
 	err = database.PostgresDB.Model(models.Transaction{}).
-		Select("users.username as to_user, amount as amount").
+		Select("users.username as to_user, amount as amount, transactions.memo, transactions.category, "+
+			"transactions.reversal_of_id is not null as reversal").
 		Joins("left join users on users.id = transactions.receiver_id").
 		Where("transactions.sender_id = ?", user.ID).
-		Order("transactions.created_at").Scan(&sent).Error
+		Scopes(history).Scan(&sent).Error
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
-		context.Abort()
-		return
+		return InfoSchema{}, http.StatusInternalServerError, err.Error()
 	}
 
-	var info = InfoSchema{
-		user.Balance,
+	return InfoSchema{
+		user.Balance - user.Debt,
 		inventory,
 		HistorySchema{received, sent},
-	}
-	context.JSON(http.StatusOK, info)
-
+	}, http.StatusOK, ""
 }
@@ -1,20 +1,23 @@
 package controllers
 
 import (
+	"avito/config"
 	"avito/database"
 	"avito/models"
-	"fmt"
+	"encoding/json"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"net/http"
+	"strings"
+	"time"
 )
 
 func BuyItem(context *gin.Context) {
-	var item models.Item
 	var user models.User
-	var err error
 
 	itemName := context.Param("item")
+	auditTarget(context, itemName)
 
 	if userId, ok := context.Get("user_id"); !ok {
 		context.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Authorization failed"})
@@ -25,35 +28,344 @@ func BuyItem(context *gin.Context) {
 		context.Abort()
 		return
 	}
-
-	if res := database.PostgresDB.Where("item_name = ?", itemName).First(&item); res.Error != nil {
-		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Could not find item"})
+	if status, message := buyItem(&user, itemName, BuyOptions{SKU: context.Query("sku"), Size: context.Query("size"),
+		Color: context.Query("color"), Promo: context.Query("promo")}); status != http.StatusOK {
+		context.JSON(status, ErrorResponse{Error: message})
 		context.Abort()
 		return
 	}
+	context.JSON(http.StatusOK, gin.H{})
+}
 
-	if user.Balance < item.Price {
-		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Insufficient funds to complete the transaction"})
-		context.Abort()
-		return
+// buyItem charges the user for the item and returns the HTTP status and
+// error message of the outcome. It is shared by every transport.
+func buyItem(user *models.User, itemName string, options BuyOptions) (int, string) {
+	var item models.Item
+
+	if user.Frozen {
+		return http.StatusForbidden, "Account is frozen"
 	}
 
-	purchase := models.Purchase{ItemID: item.ID, UserID: user.ID, Price: item.Price}
+	if res := database.PostgresDB.Preload("Variants").Where("item_name = ?", itemName).First(&item); res.Error != nil {
+		return http.StatusBadRequest, "Could not find item"
+	}
+
+	variant, err := models.SelectVariant(item.Variants, options.SKU, options.Size, options.Color)
+	if errors.Is(err, models.ErrVariantRequired) {
+		return http.StatusBadRequest, "Item variant must be specified"
+	}
+	if err != nil {
+		return http.StatusBadRequest, "Could not find item variant"
+	}
+
+	if !item.InStock() || variant != nil && !variant.InStock() {
+		return http.StatusBadRequest, "Item is sold out"
+	}
+
+	now := time.Now()
+	rules, err := models.GetPriceRulesAt(database.PostgresDB, now)
+	if err != nil {
+		return http.StatusInternalServerError, "Could not get item price"
+	}
+	listPrice, _ := models.BasePrice(&item, variant, rules, now)
+	price, rule := models.ResolvePrice(&item, variant, rules, now)
+
+	var promo *models.PromoCode
+	if options.Promo != "" {
+		found, err := models.GetPromoCode(options.Promo)
+		if err == nil {
+			err = found.Check(&item, now)
+		}
+		if err != nil {
+			return promoError(err)
+		}
+		promo = &found
+		price -= promo.Discount(price)
+	}
+
+	if user.Balance < price {
+		return http.StatusBadRequest, "Insufficient funds to complete the transaction"
+	}
+
+	purchase := models.Purchase{ItemID: item.ID, UserID: user.ID, Price: price, ListPrice: listPrice, Discount: listPrice - price}
+	if variant != nil {
+		purchase.VariantID = &variant.ID
+	}
+	if promo != nil {
+		purchase.PromoCodeID = &promo.ID
+	}
+	if rule != nil {
+		purchase.PriceRuleID = &rule.ID
+	}
 	err = database.PostgresDB.Transaction(func(tx *gorm.DB) error {
-		if err = tx.Create(&purchase).Error; err != nil {
+		if err = item.CheckUserLimit(tx, user.ID); err != nil {
 			return err
 		}
-		result := tx.Model(&user).Update("Balance", user.Balance-item.Price)
-		if result.Error != nil {
-			return result.Error
+		if err = item.TakeFromStock(tx); err != nil {
+			return err
+		}
+		if variant != nil {
+			if err = variant.TakeFromStock(tx); err != nil {
+				return err
+			}
+		}
+		if promo != nil {
+			if err = models.LockUser(tx, user.ID); err != nil {
+				return err
+			}
+			if err = promo.Redeem(tx, user.ID); err != nil {
+				return err
+			}
+		}
+		if err = models.Debit(tx, user.ID, price); err != nil {
+			return err
+		}
+		if err = models.AdjustSystemBalance(tx, models.ShopAccount, price); err != nil {
+			return err
 		}
-		if result.RowsAffected == 0 {
-			return fmt.Errorf("сould not update user's balance")
+		if err = tx.Create(&purchase).Error; err != nil {
+			return err
 		}
-		return nil
+		return models.Publish(tx, models.Event{Type: models.EventPurchaseCompleted, UserID: user.ID,
+			Data: map[string]interface{}{"purchase_id": purchase.ID, "item": item.ItemName, "price": price}})
 	})
+	if errors.Is(err, models.ErrSoldOut) {
+		return http.StatusBadRequest, "Item is sold out"
+	}
+	if errors.Is(err, models.ErrInsufficientFunds) {
+		return http.StatusBadRequest, "Insufficient funds to complete the transaction"
+	}
+	if errors.Is(err, models.ErrPromoExhausted) {
+		return promoError(err)
+	}
+	if errors.Is(err, models.ErrPurchaseLimitReached) {
+		return http.StatusBadRequest, "Purchase limit for this item is reached"
+	}
+	if err != nil {
+		return http.StatusInternalServerError, "Could not make a transaction"
+	}
+	return http.StatusOK, ""
+}
+
+func promoError(err error) (int, string) {
+	switch {
+	case errors.Is(err, models.ErrPromoNotFound):
+		return http.StatusBadRequest, "Could not find promo code"
+	case errors.Is(err, models.ErrPromoNotActive):
+		return http.StatusBadRequest, "Promo code is not active"
+	case errors.Is(err, models.ErrPromoNotApplicable):
+		return http.StatusBadRequest, "Promo code is not applicable to this item"
+	case errors.Is(err, models.ErrPromoExhausted):
+		return http.StatusBadRequest, "Promo code redemption limit is reached"
+	default:
+		return http.StatusInternalServerError, "Could not check promo code"
+	}
+}
+
+func ListItems(context *gin.Context) {
+	var query ItemsQuery
+
+	if err := context.ShouldBindQuery(&query); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+	response, status, message := listItems(query, requestLocale(context, query.Lang))
+	if status != http.StatusOK {
+		context.JSON(status, ErrorResponse{Error: message})
+		context.Abort()
+		return
+	}
+	respondWithETag(context, response)
+}
+
+// listItems applies the catalog query with prices in effect now. It is
+// shared by every transport.
+func listItems(query ItemsQuery, locale string) (ItemsSchema, int, string) {
+	var items []models.Item
+	var ids []uint
+	var total int64
+
+	if query.Limit == 0 {
+		query.Limit = 20
+	}
+	if query.Sort == "" {
+		query.Sort = "name"
+	}
+	if query.Order == "" {
+		query.Order = "asc"
+	}
+
+	now := time.Now()
+	db := database.PostgresDB.Model(&models.Item{}).Scopes(models.WithItemPrices(now))
+	if query.Category != "" {
+		db = db.Where("items.category = ?", query.Category)
+	}
+	if query.Tag != "" {
+		tag, _ := json.Marshal([]string{query.Tag})
+		db = db.Where("items.tags @> ?::jsonb", string(tag))
+	}
+	if query.MinPrice != nil {
+		db = db.Where("prices.price >= ?", *query.MinPrice)
+	}
+	if query.MaxPrice != nil {
+		db = db.Where("prices.price <= ?", *query.MaxPrice)
+	}
+	if query.Available != nil && *query.Available {
+		db = db.Where(models.ItemInStockSQL)
+	} else if query.Available != nil {
+		db = db.Where("NOT (" + models.ItemInStockSQL + ")")
+	}
+	db = db.Session(&gorm.Session{})
+	if err := db.Count(&total).Error; err != nil {
+		return ItemsSchema{}, http.StatusInternalServerError, "Could not get items"
+	}
+
+	direction := " ASC"
+	if query.Order == "desc" {
+		direction = " DESC"
+	}
+	if query.Sort == "price" {
+		db = db.Order("prices.price" + direction).Order("items.item_name")
+	} else {
+		db = db.Order("items.item_name" + direction)
+	}
+	if err := db.Limit(query.Limit).Offset(query.Offset).Pluck("items.id", &ids).Error; err != nil {
+		return ItemsSchema{}, http.StatusInternalServerError, "Could not get items"
+	}
+
+	response := ItemsSchema{Items: make([]ItemSchema, 0, len(ids)), Total: total, Limit: query.Limit, Offset: query.Offset}
+	if len(ids) == 0 {
+		return response, http.StatusOK, ""
+	}
+	if err := database.PostgresDB.Preload("Variants").Where("id IN ?", ids).Find(&items).Error; err != nil {
+		return ItemsSchema{}, http.StatusInternalServerError, "Could not get items"
+	}
+	rules, err := models.GetPriceRulesAt(database.PostgresDB, now)
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not make a transaction"})
+		return ItemsSchema{}, http.StatusInternalServerError, "Could not get items"
+	}
+	byID := make(map[uint]models.Item, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+	for _, id := range ids {
+		if item, ok := byID[id]; ok {
+			response.Items = append(response.Items, newItemSchema(item, locale, rules, now))
+		}
+	}
+	return response, http.StatusOK, ""
+}
+
+func GetItem(context *gin.Context) {
+	var item models.Item
+	if res := database.PostgresDB.Preload("Variants").Where("item_name = ?", context.Param("item")).First(&item); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find item"})
+		} else {
+			context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get item"})
+		}
+		context.Abort()
+		return
+	}
+	now := time.Now()
+	rules, err := models.GetPriceRulesAt(database.PostgresDB, now)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get item"})
+		context.Abort()
+		return
+	}
+	respondWithETag(context, newItemSchema(item, requestLocale(context, context.Query("lang")), rules, now))
+}
+
+func newItemSchema(item models.Item, locale string, rules []models.PriceRule, now time.Time) ItemSchema {
+	tags := item.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	images := make([]string, 0, len(item.Images))
+	for _, image := range item.Images {
+		images = append(images, imageURL(image))
+	}
+	variants := make([]VariantSchema, 0, len(item.Variants))
+	for _, variant := range item.Variants {
+		price, _ := models.ResolvePrice(&item, &variant, rules, now)
+		regularPrice, _ := models.BasePrice(&item, &variant, rules, now)
+		variants = append(variants, VariantSchema{
+			SKU:          variant.SKU,
+			Size:         variant.Size,
+			Color:        variant.Color,
+			Price:        price,
+			RegularPrice: regularPrice,
+			Available:    variant.InStock() && (item.Stock == nil || *item.Stock > 0),
+			Stock:        variant.Stock,
+		})
+	}
+	price, rule := models.ResolvePrice(&item, nil, rules, now)
+	regularPrice, _ := models.BasePrice(&item, nil, rules, now)
+	var sale *SaleSchema
+	if price < regularPrice {
+		sale = &SaleSchema{Name: rule.Name, EndsAt: rule.EndsAt}
+	}
+	return ItemSchema{
+		Name:         item.ItemName,
+		Title:        models.Localized(item.Title, locale, item.ItemName),
+		Description:  models.Localized(item.Description, locale, ""),
+		Category:     item.Category,
+		Tags:         tags,
+		Images:       images,
+		Price:        price,
+		RegularPrice: regularPrice,
+		Sale:         sale,
+		Available:    item.InStock(),
+		Stock:        item.Stock,
+		PerUserLimit: item.PerUserLimit,
+		Variants:     variants,
+		UpdatedAt:    item.UpdatedAt,
+	}
+}
+
+// imageURL keeps absolute references untouched and resolves file names
+// against the configured images location.
+func imageURL(image string) string {
+	if strings.Contains(image, "://") || strings.HasPrefix(image, "/") {
+		return image
+	}
+	return strings.TrimSuffix(config.Cfg.Catalog.ImagesBaseURL, "/") + "/" + image
+}
+
+// requestLocale prefers the explicit lang parameter and falls back to the
+// primary language of Accept-Language.
+func requestLocale(context *gin.Context, lang string) string {
+	if lang != "" {
+		return strings.ToLower(lang)
+	}
+	header := context.GetHeader("Accept-Language")
+	if header == "" {
+		return models.DefaultLocale
+	}
+	first := strings.Split(strings.Split(header, ",")[0], ";")[0]
+	return strings.ToLower(strings.TrimSpace(strings.Split(first, "-")[0]))
+}
+
+func SetItemStock(context *gin.Context) {
+	var payload StockPayload
+	if err := context.ShouldBindJSON(&payload); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+
+	result := database.PostgresDB.Model(&models.Item{}).
+		Where("item_name = ?", context.Param("item")).
+		UpdateColumn("stock", payload.Stock)
+	if result.Error != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not update stock"})
+		context.Abort()
+		return
+	}
+	if result.RowsAffected == 0 {
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find item"})
 		context.Abort()
 		return
 	}
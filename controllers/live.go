@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"avito/config"
+	"avito/database"
+	"avito/events"
+	"avito/models"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	"time"
+)
+
+const LiveSendCommand = "send"
+
+// liveReadLimit bounds a command frame, a transfer command is far smaller.
+const liveReadLimit = 4096
+
+// The token is sent explicitly rather than in a cookie, so a foreign page
+// can not open the socket on the user's behalf and any origin is allowed.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(*http.Request) bool { return true },
+}
+
+func liveBalance(userID uint) (float32, error) {
+	var user models.User
+	if err := database.PostgresDB.Where("ID = ?", userID).First(&user).Error; err != nil {
+		return 0, err
+	}
+	return user.Balance - user.Debt, nil
+}
+
+// Live is a WebSocket that pushes the user's events and the balance after
+// each of them, and accepts transfer commands validated like /api/sendCoin.
+// The socket is closed when the token it was opened with expires.
+func Live(context *gin.Context) {
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+	subscription := events.Subscribe(user.ID)
+	defer subscription.Close()
+
+	conn, err := upgrader.Upgrade(context.Writer, context.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	interval := config.Cfg.Events.Heartbeat
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	conn.SetReadLimit(liveReadLimit)
+	conn.SetReadDeadline(time.Now().Add(2 * interval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * interval))
+	})
+
+	// the reader only decodes commands, every write happens below
+	commands := make(chan LiveCommand)
+	done, stop := make(chan struct{}), make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(done)
+		for {
+			var command LiveCommand
+			if err := conn.ReadJSON(&command); err != nil {
+				return
+			}
+			select {
+			case commands <- command:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+	var expired <-chan time.Time
+	if expiresAt := context.GetTime("token_expires_at"); !expiresAt.IsZero() {
+		expiry := time.NewTimer(time.Until(expiresAt))
+		defer expiry.Stop()
+		expired = expiry.C
+	}
+
+	balance := user.Balance - user.Debt
+	if conn.WriteJSON(LiveMessage{Type: "balance", Balance: &balance}) != nil {
+		return
+	}
+	for {
+		var messages []LiveMessage
+		select {
+		case event := <-subscription.Events:
+			messages = append(messages, LiveMessage{Type: "event", Event: &event})
+			if balance, err := liveBalance(user.ID); err == nil {
+				messages = append(messages, LiveMessage{Type: "balance", Balance: &balance})
+			}
+		case command := <-commands:
+			messages = append(messages, liveCommand(context, user.ID, command))
+		case <-heartbeat.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)) != nil {
+				return
+			}
+		case <-expired:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "token is expired"),
+				time.Now().Add(interval))
+			return
+		case <-done:
+			return
+		}
+		for _, message := range messages {
+			if conn.WriteJSON(message) != nil {
+				return
+			}
+		}
+	}
+}
+
+// liveCommand runs a transfer for the socket and records it in the audit log
+// like the HTTP endpoint would be.
+func liveCommand(context *gin.Context, userID uint, command LiveCommand) LiveMessage {
+	result := LiveMessage{Type: "result", ID: command.ID, Status: http.StatusOK}
+	var user models.User
+
+	if command.Type != LiveSendCommand {
+		result.Status, result.Error = http.StatusBadRequest, "Unknown command"
+		return result
+	}
+	if err := binding.Validator.ValidateStruct(command.SendToPayload); err != nil {
+		result.Status, result.Error = http.StatusBadRequest, err.Error()
+		return result
+	}
+	if err := database.PostgresDB.Where("ID = ?", userID).First(&user).Error; err != nil {
+		result.Status, result.Error = http.StatusUnauthorized, "Authorization failed"
+		return result
+	}
+	result.Status, result.Error = sendCoins(&user, command.SendToPayload)
+
+	entry := models.AuditLog{
+		ActorID:   &userID,
+		Action:    "WS " + LiveSendCommand,
+		Target:    command.ToUser,
+		RequestID: context.GetString("request_id"),
+		IP:        context.ClientIP(),
+		Outcome:   models.AuditSuccess,
+		Status:    result.Status,
+		Detail:    result.Error,
+	}
+	if result.Status >= http.StatusBadRequest {
+		entry.Outcome = models.AuditFailure
+	}
+	if err := models.WriteAudit(database.PostgresDB, &entry); err != nil {
+		log.Printf("[audit] could not write %s by %d: %v", entry.Action, userID, err)
+	}
+	return result
+}
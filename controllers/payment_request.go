@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"avito/config"
+	"avito/database"
+	"avito/models"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+func CreatePaymentRequest(context *gin.Context) {
+	var payload PaymentRequestPayload
+	var payer models.User
+
+	if err := context.ShouldBindJSON(&payload); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+	if res := database.PostgresDB.Where("Username = ?", payload.Payer).First(&payer); res.Error != nil || payer.ID == user.ID || payer.IsSystem() {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Incorrect payer's username"})
+		context.Abort()
+		return
+	}
+
+	if payload.Category == "" {
+		payload.Category = models.CategoryOther
+	}
+	request := models.PaymentRequest{
+		RequesterID: user.ID,
+		PayerID:     payer.ID,
+		Amount:      payload.Amount,
+		Memo:        models.SanitizeMemo(payload.Memo),
+		Category:    payload.Category,
+		Status:      models.PaymentRequestPending,
+		ExpiresAt:   time.Now().Add(config.Cfg.Payments.RequestTTL),
+	}
+	err := database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&request).Error; err != nil {
+			return err
+		}
+		return models.PaymentRequested(tx, &request)
+	})
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not create payment request"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusCreated, request)
+}
+
+func ListPaymentRequests(context *gin.Context) {
+	var requests []PaymentRequestSchema
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+
+	db := database.PostgresDB.Model(models.PaymentRequest{}).
+		Select("payment_requests.id, requesters.username as requester, payers.username as payer, " +
+			"payment_requests.amount, payment_requests.memo, payment_requests.category, payment_requests.status, " +
+			"payment_requests.created_at, payment_requests.expires_at, payment_requests.responded_at, " +
+			"payment_requests.transaction_id").
+		Joins("left join users requesters on requesters.id = payment_requests.requester_id").
+		Joins("left join users payers on payers.id = payment_requests.payer_id")
+	switch context.DefaultQuery("direction", "incoming") {
+	case "incoming":
+		db = db.Where("payment_requests.payer_id = ?", user.ID)
+	case "outgoing":
+		db = db.Where("payment_requests.requester_id = ?", user.ID)
+	default:
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Incorrect direction value"})
+		context.Abort()
+		return
+	}
+	if status := context.Query("status"); status != "" {
+		db = db.Where("payment_requests.status = ?", status)
+	}
+
+	if err := models.ExpirePaymentRequests(database.PostgresDB, time.Now()); err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get payment requests"})
+		context.Abort()
+		return
+	}
+	if err := db.Order("payment_requests.created_at desc").Scan(&requests).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get payment requests"})
+		context.Abort()
+		return
+	}
+	if requests == nil {
+		requests = []PaymentRequestSchema{}
+	}
+	context.JSON(http.StatusOK, requests)
+}
+
+func AcceptPaymentRequest(context *gin.Context) {
+	respondPaymentRequest(context, (*models.PaymentRequest).Accept)
+}
+
+func DeclinePaymentRequest(context *gin.Context) {
+	respondPaymentRequest(context, (*models.PaymentRequest).Decline)
+}
+
+func respondPaymentRequest(context *gin.Context, decide func(*models.PaymentRequest, *gorm.DB, time.Time) error) {
+	var request models.PaymentRequest
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+
+	err := database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if request, err = models.LockPaymentRequest(tx, context.Param("id"), user.ID); err != nil {
+			return err
+		}
+		return decide(&request, tx, time.Now())
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find payment request"})
+	case errors.Is(err, models.ErrPaymentRequestClosed):
+		context.JSON(http.StatusConflict, ErrorResponse{Error: "Payment request is already closed"})
+	case errors.Is(err, models.ErrPaymentRequestExpired):
+		context.JSON(http.StatusConflict, ErrorResponse{Error: "Payment request is expired"})
+	case errors.Is(err, models.ErrAccountFrozen):
+		context.JSON(http.StatusForbidden, ErrorResponse{Error: "Account is frozen"})
+	case errors.Is(err, models.ErrInsufficientFunds):
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Insufficient funds to complete the transaction"})
+	case err != nil:
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not respond to payment request"})
+	default:
+		context.JSON(http.StatusOK, request)
+		return
+	}
+	context.Abort()
+}
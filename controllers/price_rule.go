@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"avito/database"
+	"avito/models"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+func CreatePriceRule(context *gin.Context) {
+	var rule models.PriceRule
+	if err := context.ShouldBindJSON(&rule); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+	rule.ID = 0
+	if rule.Kind == models.DiscountPercent && rule.Value > 100 {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Percent discount can not exceed 100"})
+		context.Abort()
+		return
+	}
+	if rule.EndsAt != nil && !rule.StartsAt.Before(*rule.EndsAt) {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "starts_at must be before ends_at"})
+		context.Abort()
+		return
+	}
+	if err := database.PostgresDB.Create(&rule).Error; err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Could not create price rule"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusCreated, rule)
+}
+
+// ListPriceRules returns every rule ever created, including ended and
+// cancelled ones, so that purchases can be traced to their rule.
+func ListPriceRules(context *gin.Context) {
+	var rules []models.PriceRule
+	if err := database.PostgresDB.Unscoped().Order("starts_at desc, id desc").Find(&rules).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get price rules"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, rules)
+}
+
+// EndPriceRule stops a running rule now; a rule that has not started yet is
+// cancelled altogether.
+func EndPriceRule(context *gin.Context) {
+	var rule models.PriceRule
+	if res := database.PostgresDB.Where("id = ?", context.Param("id")).First(&rule); res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find price rule"})
+		} else {
+			context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get price rule"})
+		}
+		context.Abort()
+		return
+	}
+
+	now := time.Now()
+	var err error
+	switch {
+	case now.Before(rule.StartsAt):
+		err = database.PostgresDB.Delete(&rule).Error
+	case rule.EndsAt == nil || now.Before(*rule.EndsAt):
+		err = database.PostgresDB.Model(&rule).Update("ends_at", now).Error
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not end price rule"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{})
+}
+
+func PreviewPrices(context *gin.Context) {
+	var items []models.Item
+	moment := time.Now()
+	if at := context.Query("at"); at != "" {
+		parsed, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Incorrect at value, RFC 3339 expected"})
+			context.Abort()
+			return
+		}
+		moment = parsed
+	}
+
+	if err := database.PostgresDB.Preload("Variants").Order("item_name").Find(&items).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get items"})
+		context.Abort()
+		return
+	}
+	rules, err := models.GetPriceRulesAt(database.PostgresDB, moment)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get price rules"})
+		context.Abort()
+		return
+	}
+
+	preview := PricePreviewSchema{At: moment, Items: make([]ItemPriceSchema, 0, len(items))}
+	for _, item := range items {
+		price, rule := models.ResolvePrice(&item, nil, rules, moment)
+		entry := ItemPriceSchema{Name: item.ItemName, RegularPrice: item.Price, Price: price}
+		if rule != nil {
+			entry.RuleID = &rule.ID
+			entry.RuleName = rule.Name
+		}
+		preview.Items = append(preview.Items, entry)
+	}
+	context.JSON(http.StatusOK, preview)
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"avito/database"
+	"avito/models"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+func CreatePromoCode(context *gin.Context) {
+	var promo models.PromoCode
+	if err := context.ShouldBindJSON(&promo); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+	promo.ID = 0
+	promo.Redemptions = 0
+	promo.Code = models.NormalizePromoCode(promo.Code)
+	if promo.Kind == models.DiscountPercent && promo.Value > 100 {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Percent discount can not exceed 100"})
+		context.Abort()
+		return
+	}
+	if promo.ValidFrom != nil && promo.ValidUntil != nil && !promo.ValidFrom.Before(*promo.ValidUntil) {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "valid_from must be before valid_until"})
+		context.Abort()
+		return
+	}
+
+	if err := database.PostgresDB.Create(&promo).Error; err != nil {
+		var pgErr *pgconn.PgError
+		if errors.Is(err, gorm.ErrDuplicatedKey) || errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			context.JSON(http.StatusConflict, ErrorResponse{Error: "Promo code already exists"})
+			context.Abort()
+			return
+		}
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Could not create promo code"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusCreated, promo)
+}
+
+func ListPromoCodes(context *gin.Context) {
+	var promos []models.PromoCode
+	if err := database.PostgresDB.Order("created_at desc").Find(&promos).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get promo codes"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, promos)
+}
+
+func DeletePromoCode(context *gin.Context) {
+	result := database.PostgresDB.Where("code = ?", models.NormalizePromoCode(context.Param("code"))).
+		Delete(&models.PromoCode{})
+	if result.Error != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not delete promo code"})
+		context.Abort()
+		return
+	}
+	if result.RowsAffected == 0 {
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find promo code"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{})
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"avito/database"
+	"avito/models"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func GetReconciliation(context *gin.Context) {
+	run, err := models.GetLastReconciliation(database.PostgresDB)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Reconciliation has not run yet"})
+		context.Abort()
+		return
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get reconciliation"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, run)
+}
+
+func RunReconciliation(context *gin.Context) {
+	freeze, _ := strconv.ParseBool(context.DefaultQuery("freeze", "false"))
+	run, err := models.Reconcile(database.PostgresDB, freeze, time.Now())
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not reconcile balances"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, run)
+}
+
+func UnfreezeUser(context *gin.Context) {
+	result := database.PostgresDB.Model(&models.User{}).
+		Where("username = ? AND role <> ?", context.Param("username"), models.RoleSystem).
+		Update("frozen", false)
+	if result.Error != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not unfreeze user"})
+		context.Abort()
+		return
+	}
+	if result.RowsAffected == 0 {
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find user"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{})
+}
+
+// Metrics exposes the coin supply and the last reconciliation in the
+// Prometheus text format.
+func Metrics(context *gin.Context) {
+	var frozen int64
+	circulating, imbalance, err := models.Supply(database.PostgresDB)
+	if err == nil {
+		err = database.PostgresDB.Model(&models.User{}).Where("frozen").Count(&frozen).Error
+	}
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not collect metrics"})
+		context.Abort()
+		return
+	}
+
+	var metrics strings.Builder
+	gauge := func(name, help string, value interface{}) {
+		fmt.Fprintf(&metrics, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+	gauge("coins_circulating", "Coins held by users.", circulating)
+	gauge("coins_imbalance", "Sum of all balances including system accounts, zero when consistent.", imbalance)
+	gauge("users_frozen", "Users frozen by reconciliation.", frozen)
+	if run, err := models.GetLastReconciliation(database.PostgresDB); err == nil {
+		gauge("reconciliation_last_run_timestamp_seconds", "Time of the last reconciliation.", run.FinishedAt.Unix())
+		gauge("reconciliation_discrepancies", "Users whose balance did not match the ledger.", len(run.Discrepancies))
+		gauge("reconciliation_imbalance", "Imbalance found by the last reconciliation.", run.Imbalance)
+	}
+	context.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(metrics.String()))
+}
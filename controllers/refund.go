@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"avito/config"
+	"avito/database"
+	"avito/models"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+func ListPurchases(context *gin.Context) {
+	var purchases []PurchaseSchema
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+
+	err := database.PostgresDB.Model(models.Purchase{}).
+		Select("purchases.id, items.item_name as item, coalesce(item_variants.sku, '') as sku, purchases.price, "+
+			"purchases.list_price, purchases.discount, purchases.created_at, purchases.returned_at").
+		Joins("left join items on items.id = purchases.item_id").
+		Joins("left join item_variants on item_variants.id = purchases.variant_id").
+		Where("purchases.user_id = ?", user.ID).
+		Order("purchases.created_at desc").Scan(&purchases).Error
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get purchases"})
+		context.Abort()
+		return
+	}
+	if purchases == nil {
+		purchases = []PurchaseSchema{}
+	}
+	context.JSON(http.StatusOK, purchases)
+}
+
+func RequestRefund(context *gin.Context) {
+	var payload RefundPayload
+	var purchase models.Purchase
+	var refund models.Refund
+
+	if err := context.ShouldBindJSON(&payload); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+	if res := database.PostgresDB.Where("id = ? AND user_id = ?", context.Param("id"), user.ID).
+		First(&purchase); res.Error != nil {
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find purchase"})
+		context.Abort()
+		return
+	}
+
+	err := database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		refund, err = models.RequestRefund(tx, &purchase, payload.Reason, config.Cfg.Refund.Window)
+		return err
+	})
+	switch {
+	case errors.Is(err, models.ErrRefundWindowClosed):
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Refund window for this purchase is closed"})
+	case errors.Is(err, models.ErrRefundExists):
+		context.JSON(http.StatusConflict, ErrorResponse{Error: "Refund for this purchase is already requested"})
+	case errors.Is(err, models.ErrPurchaseReturned):
+		context.JSON(http.StatusConflict, ErrorResponse{Error: "Purchase is already returned"})
+	case err != nil:
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not request refund"})
+	default:
+		context.JSON(http.StatusCreated, refund)
+		return
+	}
+	context.Abort()
+}
+
+func ListRefunds(context *gin.Context) {
+	var refunds []models.Refund
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+	if err := database.PostgresDB.Where("user_id = ?", user.ID).Order("created_at desc").
+		Find(&refunds).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get refunds"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, refunds)
+}
+
+func ListAllRefunds(context *gin.Context) {
+	var refunds []models.Refund
+	db := database.PostgresDB.Order("created_at")
+	if status := context.Query("status"); status != "" {
+		db = db.Where("status = ?", status)
+	}
+	if err := db.Find(&refunds).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get refunds"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, refunds)
+}
+
+func ApproveRefund(context *gin.Context) {
+	reviewRefund(context, (*models.Refund).Approve)
+}
+
+func RejectRefund(context *gin.Context) {
+	reviewRefund(context, (*models.Refund).Reject)
+}
+
+func reviewRefund(context *gin.Context, decide func(*models.Refund, *gorm.DB, uint, string) error) {
+	var payload ReviewPayload
+	var refund models.Refund
+
+	// the comment is optional, so an empty body is accepted as well
+	if context.Request.ContentLength != 0 {
+		if err := context.ShouldBindJSON(&payload); err != nil {
+			context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+			context.Abort()
+			return
+		}
+	}
+	reviewer, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+
+	err := database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if refund, err = models.LockRefund(tx, context.Param("id")); err != nil {
+			return err
+		}
+		if err := decide(&refund, tx, reviewer.ID, payload.Comment); err != nil {
+			return err
+		}
+		// the decision is audited in its own tx, so it is never lost or
+		// recorded for a review that rolled back
+		return models.WriteAudit(tx, &models.AuditLog{
+			ActorID:   &reviewer.ID,
+			Action:    "refund " + refund.Status,
+			Target:    "refund=" + strconv.FormatUint(uint64(refund.ID), 10),
+			RequestID: context.GetString("request_id"),
+			IP:        context.ClientIP(),
+			Outcome:   models.AuditSuccess,
+			Status:    http.StatusOK,
+			Detail:    payload.Comment,
+		})
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find refund"})
+	case errors.Is(err, models.ErrRefundAlreadyClosed):
+		context.JSON(http.StatusConflict, ErrorResponse{Error: "Refund is already reviewed"})
+	case errors.Is(err, models.ErrPurchaseReturned):
+		context.JSON(http.StatusConflict, ErrorResponse{Error: "Purchase is already returned"})
+	case err != nil:
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not review refund"})
+	default:
+		context.JSON(http.StatusOK, refund)
+		return
+	}
+	context.Abort()
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"avito/database"
+	"avito/models"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/http"
+	"time"
+)
+
+func CreateScheduledTransfer(context *gin.Context) {
+	var payload ScheduledTransferPayload
+	var receiver models.User
+
+	if err := context.ShouldBindJSON(&payload); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+	if res := database.PostgresDB.Where("Username = ?", payload.ToUser).First(&receiver); res.Error != nil || receiver.ID == user.ID || receiver.IsSystem() {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Incorrect receiver's username"})
+		context.Abort()
+		return
+	}
+
+	startAt := time.Now()
+	if payload.StartAt != nil {
+		startAt = *payload.StartAt
+	}
+	if payload.Interval == "" {
+		payload.Interval = models.ScheduleOnce
+	}
+	if payload.Category == "" {
+		payload.Category = models.CategoryOther
+	}
+	schedule := models.ScheduledTransfer{
+		SenderID:   user.ID,
+		ReceiverID: receiver.ID,
+		Amount:     payload.Amount,
+		Memo:       models.SanitizeMemo(payload.Memo),
+		Category:   payload.Category,
+		Interval:   payload.Interval,
+		Status:     models.ScheduledTransferActive,
+		NextRunAt:  startAt,
+	}
+	if err := database.PostgresDB.Create(&schedule).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not create scheduled transfer"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusCreated, schedule)
+}
+
+func ListScheduledTransfers(context *gin.Context) {
+	var schedules []models.ScheduledTransfer
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+	db := database.PostgresDB.Where("sender_id = ?", user.ID)
+	if status := context.Query("status"); status != "" {
+		db = db.Where("status = ?", status)
+	}
+	if err := db.Order("created_at desc").Find(&schedules).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get scheduled transfers"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, schedules)
+}
+
+func ListScheduledTransferRuns(context *gin.Context) {
+	var schedule models.ScheduledTransfer
+	var runs []models.ScheduledTransferRun
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+	if res := database.PostgresDB.Where("id = ? AND sender_id = ?", context.Param("id"), user.ID).
+		First(&schedule); res.Error != nil {
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find scheduled transfer"})
+		context.Abort()
+		return
+	}
+	if err := database.PostgresDB.Where("scheduled_transfer_id = ?", schedule.ID).Order("slot desc").
+		Find(&runs).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get scheduled transfer runs"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, runs)
+}
+
+func CancelScheduledTransfer(context *gin.Context) {
+	var schedule models.ScheduledTransfer
+	user, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+
+	err := database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND sender_id = ?", context.Param("id"), user.ID).First(&schedule).Error; err != nil {
+			return err
+		}
+		return schedule.Cancel(tx)
+	})
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find scheduled transfer"})
+	case errors.Is(err, models.ErrScheduledTransferClosed):
+		context.JSON(http.StatusConflict, ErrorResponse{Error: "Scheduled transfer is not active"})
+	case err != nil:
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not cancel scheduled transfer"})
+	default:
+		context.JSON(http.StatusOK, schedule)
+		return
+	}
+	context.Abort()
+}
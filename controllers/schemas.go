@@ -1,27 +1,48 @@
 package controllers
 
+import (
+	"avito/models"
+	"time"
+)
+
 type TokenResponse struct {
 	SignedToken string `json:"token"`
 }
 
 type SendToPayload struct {
-	ToUser string  `json:"toUser" binding:"required"`
-	Amount float32 `json:"amount" binding:"required"`
+	ToUser   string  `json:"toUser" binding:"required"`
+	Amount   float32 `json:"amount" binding:"required"`
+	Memo     string  `json:"memo" binding:"max=200"`
+	Category string  `json:"category" binding:"omitempty,oneof=thanks bet payback gift other"`
+}
+
+type ReversePayload struct {
+	Reason        string `json:"reason" binding:"required,max=500"`
+	AllowNegative bool   `json:"allow_negative"`
 }
 
 type InventorySchema struct {
 	Type     string `json:"type"`
+	SKU      string `gorm:"column:sku" json:"sku,omitempty"`
+	Size     string `json:"size,omitempty"`
+	Color    string `json:"color,omitempty"`
 	Quantity uint64 `json:"quantity"`
 }
 
 type ReceivedSchema struct {
 	FromUser string  `gorm:"column:from_user" json:"fromUser"`
 	Amount   float32 `json:"amount"`
+	Memo     string  `json:"memo,omitempty"`
+	Category string  `json:"category,omitempty"`
+	Reversal bool    `gorm:"column:reversal" json:"reversal,omitempty"`
 }
 
 type SentSchema struct {
-	ToUser string  `gorm:"column:to_user" json:"toUser"`
-	Amount float32 `json:"amount"`
+	ToUser   string  `gorm:"column:to_user" json:"toUser"`
+	Amount   float32 `json:"amount"`
+	Memo     string  `json:"memo,omitempty"`
+	Category string  `json:"category,omitempty"`
+	Reversal bool    `gorm:"column:reversal" json:"reversal,omitempty"`
 }
 
 type HistorySchema struct {
@@ -36,3 +57,243 @@ type InfoSchema struct {
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
+
+type ItemSchema struct {
+	Name         string          `json:"name"`
+	Title        string          `json:"title"`
+	Description  string          `json:"description"`
+	Category     string          `json:"category"`
+	Tags         []string        `json:"tags"`
+	Images       []string        `json:"images"`
+	Price        float32         `json:"price"`
+	RegularPrice float32         `json:"regularPrice"`
+	Sale         *SaleSchema     `json:"sale"`
+	Available    bool            `json:"available"`
+	Stock        *int            `json:"stock"`
+	PerUserLimit *int            `json:"perUserLimit"`
+	Variants     []VariantSchema `json:"variants"`
+	UpdatedAt    time.Time       `json:"updatedAt"`
+}
+
+type VariantSchema struct {
+	SKU          string  `json:"sku"`
+	Size         string  `json:"size,omitempty"`
+	Color        string  `json:"color,omitempty"`
+	Price        float32 `json:"price"`
+	RegularPrice float32 `json:"regularPrice"`
+	Available    bool    `json:"available"`
+	Stock        *int    `json:"stock"`
+}
+
+type SaleSchema struct {
+	Name   string     `json:"name"`
+	EndsAt *time.Time `json:"endsAt"`
+}
+
+type BuyOptions struct {
+	SKU   string
+	Size  string
+	Color string
+	Promo string
+}
+
+type ItemsQuery struct {
+	MinPrice  *float32 `form:"min_price" binding:"omitempty,min=0"`
+	MaxPrice  *float32 `form:"max_price" binding:"omitempty,min=0"`
+	Available *bool    `form:"available"`
+	Category  string   `form:"category"`
+	Tag       string   `form:"tag"`
+	Lang      string   `form:"lang"`
+	Sort      string   `form:"sort" binding:"omitempty,oneof=name price"`
+	Order     string   `form:"order" binding:"omitempty,oneof=asc desc"`
+	Limit     int      `form:"limit" binding:"omitempty,min=1,max=100"`
+	Offset    int      `form:"offset" binding:"omitempty,min=0"`
+}
+
+type ItemsSchema struct {
+	Items  []ItemSchema `json:"items"`
+	Total  int64        `json:"total"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+}
+
+// StockPayload with "stock": null makes the item unlimited again.
+type StockPayload struct {
+	Stock *int `json:"stock" binding:"omitempty,min=0"`
+}
+
+type ItemPriceSchema struct {
+	Name         string  `json:"name"`
+	RegularPrice float32 `json:"regularPrice"`
+	Price        float32 `json:"price"`
+	RuleID       *uint   `json:"ruleId"`
+	RuleName     string  `json:"ruleName,omitempty"`
+}
+
+type PricePreviewSchema struct {
+	At    time.Time         `json:"at"`
+	Items []ItemPriceSchema `json:"items"`
+}
+
+type PurchaseSchema struct {
+	ID         uint       `json:"id"`
+	Item       string     `gorm:"column:item" json:"item"`
+	SKU        string     `gorm:"column:sku" json:"sku,omitempty"`
+	Price      float32    `json:"price"`
+	ListPrice  float32    `json:"listPrice"`
+	Discount   float32    `json:"discount"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ReturnedAt *time.Time `json:"returnedAt"`
+}
+
+type RefundPayload struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+type ReviewPayload struct {
+	Comment string `json:"comment" binding:"max=500"`
+}
+
+type PaymentRequestPayload struct {
+	Payer    string  `json:"payer" binding:"required"`
+	Amount   float32 `json:"amount" binding:"required,gt=0"`
+	Memo     string  `json:"memo" binding:"max=200"`
+	Category string  `json:"category" binding:"omitempty,oneof=thanks bet payback gift other"`
+}
+
+type PaymentRequestSchema struct {
+	ID            uint       `json:"id"`
+	Requester     string     `gorm:"column:requester" json:"requester"`
+	Payer         string     `gorm:"column:payer" json:"payer"`
+	Amount        float32    `json:"amount"`
+	Memo          string     `json:"memo,omitempty"`
+	Category      string     `json:"category,omitempty"`
+	Status        string     `json:"status"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	ExpiresAt     time.Time  `json:"expiresAt"`
+	RespondedAt   *time.Time `json:"respondedAt"`
+	TransactionID *uint      `json:"transactionId,omitempty"`
+}
+
+type GroupSharePayload struct {
+	User   string  `json:"user" binding:"required"`
+	Amount float32 `json:"amount" binding:"min=0"`
+}
+
+type GroupRequestPayload struct {
+	Total    float32             `json:"total" binding:"required,gt=0"`
+	Memo     string              `json:"memo" binding:"max=200"`
+	Category string              `json:"category" binding:"omitempty,oneof=thanks bet payback gift other"`
+	Shares   []GroupSharePayload `json:"shares" binding:"required,min=1,max=50,dive"`
+}
+
+type GroupShareSchema struct {
+	User          string     `json:"user"`
+	Amount        float32    `json:"amount"`
+	PaidAt        *time.Time `json:"paidAt"`
+	TransactionID *uint      `json:"transactionId,omitempty"`
+}
+
+type GroupRequestSchema struct {
+	ID        uint               `json:"id"`
+	Requester string             `json:"requester"`
+	Total     float32            `json:"total"`
+	Collected float32            `json:"collected"`
+	Memo      string             `json:"memo,omitempty"`
+	Category  string             `json:"category,omitempty"`
+	Status    string             `json:"status"`
+	CreatedAt time.Time          `json:"createdAt"`
+	FundedAt  *time.Time         `json:"fundedAt"`
+	Shares    []GroupShareSchema `json:"shares"`
+}
+
+type ScheduledTransferPayload struct {
+	ToUser   string     `json:"toUser" binding:"required"`
+	Amount   float32    `json:"amount" binding:"required,gt=0"`
+	Memo     string     `json:"memo" binding:"max=200"`
+	Category string     `json:"category" binding:"omitempty,oneof=thanks bet payback gift other"`
+	Interval string     `json:"interval" binding:"omitempty,oneof=once daily weekly monthly"`
+	StartAt  *time.Time `json:"start_at"`
+}
+
+type GrantPayload struct {
+	ToUser string  `json:"toUser" binding:"required"`
+	Amount float32 `json:"amount" binding:"required,gt=0"`
+	Memo   string  `json:"memo" binding:"max=200"`
+}
+
+type BurnPayload struct {
+	User   string  `json:"user" binding:"required"`
+	Amount float32 `json:"amount" binding:"required,gt=0"`
+	Memo   string  `json:"memo" binding:"max=200"`
+}
+
+type SystemTransferPayload struct {
+	From   string  `json:"from" binding:"required"`
+	To     string  `json:"to" binding:"required,nefield=From"`
+	Amount float32 `json:"amount" binding:"required,gt=0"`
+	Memo   string  `json:"memo" binding:"max=200"`
+}
+
+type SystemAccountSchema struct {
+	Name    string  `json:"name"`
+	Balance float32 `json:"balance"`
+}
+
+type AccountsSchema struct {
+	Accounts    []SystemAccountSchema `json:"accounts"`
+	Circulating float32               `json:"circulating"`
+	Imbalance   float32               `json:"imbalance"`
+}
+
+type AuditQuery struct {
+	Actor     string     `form:"actor"`
+	Action    string     `form:"action"`
+	Target    string     `form:"target"`
+	Outcome   string     `form:"outcome" binding:"omitempty,oneof=success failure"`
+	RequestID string     `form:"request_id"`
+	From      *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To        *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit     int        `form:"limit" binding:"omitempty,min=1,max=1000"`
+	Offset    int        `form:"offset" binding:"omitempty,min=0"`
+}
+
+type StatementQuery struct {
+	From   *time.Time `form:"from" time_format:"2006-01-02" time_utc:"1"`
+	To     *time.Time `form:"to" time_format:"2006-01-02" time_utc:"1"`
+	Format string     `form:"format" binding:"omitempty,oneof=csv json"`
+}
+
+type LiveCommand struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	SendToPayload
+}
+
+type LiveMessage struct {
+	Type    string        `json:"type"`
+	ID      string        `json:"id,omitempty"`
+	Balance *float32      `json:"balance,omitempty"`
+	Event   *models.Event `json:"event,omitempty"`
+	Status  int           `json:"status,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+type WebhookPayload struct {
+	URL         string   `json:"url" binding:"required,url"`
+	Secret      string   `json:"secret" binding:"omitempty,min=16"`
+	EventTypes  []string `json:"event_types" binding:"dive,oneof=coin_received coin_sent purchase_completed payment_request_created payment_request_accepted payment_request_declined transaction_created purchase_created"`
+	Description string   `json:"description" binding:"max=200"`
+}
+
+type WebhookCreatedSchema struct {
+	models.WebhookSubscription
+	Secret string `json:"secret"`
+}
+
+type DeliveriesQuery struct {
+	Status         string `form:"status" binding:"omitempty,oneof=pending delivered dead"`
+	SubscriptionID uint   `form:"subscription_id"`
+	Limit          int    `form:"limit" binding:"omitempty,min=1,max=1000"`
+	Offset         int    `form:"offset" binding:"omitempty,min=0"`
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"avito/database"
+	"avito/models"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const statementFlushEvery = 100
+
+func GetStatement(context *gin.Context) {
+	var user models.User
+	var query StatementQuery
+
+	if userId, ok := context.Get("user_id"); !ok {
+		context.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Authorization failed"})
+		context.Abort()
+		return
+	} else if res := database.PostgresDB.Where("ID = ?", userId).First(&user); res.Error != nil {
+		context.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Authorization failed"})
+		context.Abort()
+		return
+	}
+	if err := context.ShouldBindQuery(&query); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+
+	// both dates are inclusive, the current month is the default period
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if query.From != nil {
+		from = *query.From
+	}
+	if query.To != nil {
+		to = *query.To
+	}
+	if to.Before(from) {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Period ends before it starts"})
+		context.Abort()
+		return
+	}
+	to = to.AddDate(0, 0, 1)
+
+	statement, err := models.OpenStatement(database.PostgresDB, user.ID, from, to)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get statement"})
+		context.Abort()
+		return
+	}
+	defer statement.Close()
+
+	name := fmt.Sprintf("statement-%s-%s", from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02"))
+	if query.Format == "csv" {
+		context.Header("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+		context.Header("Content-Type", "text/csv; charset=utf-8")
+		err = writeCSVStatement(context, statement, from, to)
+	} else {
+		context.Header("Content-Type", "application/json; charset=utf-8")
+		err = writeJSONStatement(context, user, statement, from, to)
+	}
+	// the status is already sent, the response is cut short
+	if err != nil {
+		log.Printf("[statement] %s: %v", user.Username, err)
+		context.Abort()
+	}
+}
+
+func formatCoins(amount float32) string {
+	return strconv.FormatFloat(float64(amount), 'f', 2, 32)
+}
+
+// csvText keeps a spreadsheet from reading user text as a formula.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func writeCSVStatement(context *gin.Context, statement *models.Statement, from time.Time, to time.Time) error {
+	var entry models.StatementEntry
+	writer := csv.NewWriter(context.Writer)
+	context.Status(http.StatusOK)
+
+	writer.Write([]string{"at", "kind", "counterparty", "amount", "balance", "memo", "category", "reference"})
+	writer.Write([]string{from.Format(time.RFC3339), "opening", "", "", formatCoins(statement.Opening), "", "", ""})
+	for count := 1; ; count++ {
+		ok, err := statement.Next(&entry)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		writer.Write([]string{entry.At.UTC().Format(time.RFC3339), entry.Kind, csvText(entry.Counterparty),
+			formatCoins(entry.Amount), formatCoins(entry.Balance), csvText(entry.Memo), entry.Category,
+			strconv.FormatUint(uint64(entry.Reference), 10)})
+		if count%statementFlushEvery == 0 {
+			writer.Flush()
+			context.Writer.Flush()
+		}
+	}
+	writer.Write([]string{to.Format(time.RFC3339), "closing", "", "", formatCoins(statement.Closing), "", "", ""})
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeJSONStatement(context *gin.Context, user models.User, statement *models.Statement, from time.Time, to time.Time) error {
+	var entry models.StatementEntry
+	context.Status(http.StatusOK)
+
+	header, _ := json.Marshal(map[string]interface{}{
+		"username":        user.Username,
+		"from":            from,
+		"to":              to,
+		"opening_balance": statement.Opening,
+	})
+	// the object is written by hand to stream the entries, the closing
+	// balance is only known at the end
+	if _, err := fmt.Fprintf(context.Writer, `%s,"entries":[`, header[:len(header)-1]); err != nil {
+		return err
+	}
+	for count := 0; ; count++ {
+		ok, err := statement.Next(&entry)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		entry.At = entry.At.UTC()
+		line, _ := json.Marshal(entry)
+		if count > 0 {
+			context.Writer.WriteString(",")
+		}
+		if _, err := context.Writer.Write(line); err != nil {
+			return err
+		}
+		if (count+1)%statementFlushEvery == 0 {
+			context.Writer.Flush()
+		}
+	}
+	closing, _ := json.Marshal(statement.Closing)
+	_, err := fmt.Fprintf(context.Writer, `],"closing_balance":%s}`, closing)
+	return err
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"avito/database"
+	"avito/models"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+)
+
+func ListSystemAccounts(context *gin.Context) {
+	var accounts []models.User
+	var schema AccountsSchema
+
+	if err := database.PostgresDB.Where("role = ?", models.RoleSystem).Order("username").
+		Find(&accounts).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get system accounts"})
+		context.Abort()
+		return
+	}
+	circulating, imbalance, err := models.Supply(database.PostgresDB)
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get system accounts"})
+		context.Abort()
+		return
+	}
+	schema.Accounts = make([]SystemAccountSchema, 0, len(accounts))
+	for _, account := range accounts {
+		schema.Accounts = append(schema.Accounts, SystemAccountSchema{Name: account.Username, Balance: account.Balance})
+	}
+	schema.Circulating, schema.Imbalance = circulating, imbalance
+	context.JSON(http.StatusOK, schema)
+}
+
+func GrantCoins(context *gin.Context) {
+	var payload GrantPayload
+	var receiver models.User
+	var transaction models.Transaction
+
+	if err := context.ShouldBindJSON(&payload); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+	if res := database.PostgresDB.Where("Username = ?", payload.ToUser).First(&receiver); res.Error != nil || receiver.IsSystem() {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Incorrect receiver's username"})
+		context.Abort()
+		return
+	}
+	err := database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		transaction, err = models.Mint(tx, receiver.ID, payload.Amount, models.SanitizeMemo(payload.Memo), models.CategoryGrant)
+		return err
+	})
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not grant coins"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, transaction)
+}
+
+func BurnCoins(context *gin.Context) {
+	var payload BurnPayload
+	var user models.User
+	var transaction models.Transaction
+
+	if err := context.ShouldBindJSON(&payload); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+	if res := database.PostgresDB.Where("Username = ?", payload.User).First(&user); res.Error != nil || user.IsSystem() {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Incorrect username"})
+		context.Abort()
+		return
+	}
+	err := database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		transaction, err = models.Burn(tx, user.ID, payload.Amount, models.SanitizeMemo(payload.Memo))
+		return err
+	})
+	switch {
+	case errors.Is(err, models.ErrInsufficientFunds):
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Insufficient funds to complete the transaction"})
+	case err != nil:
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not burn coins"})
+	default:
+		context.JSON(http.StatusOK, transaction)
+		return
+	}
+	context.Abort()
+}
+
+func TransferSystemCoins(context *gin.Context) {
+	var payload SystemTransferPayload
+	var transaction models.Transaction
+
+	if err := context.ShouldBindJSON(&payload); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+	err := database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		transaction, err = models.MoveSystemCoins(tx, payload.From, payload.To, payload.Amount, models.SanitizeMemo(payload.Memo))
+		return err
+	})
+	switch {
+	case errors.Is(err, models.ErrNotSystemAccount):
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Incorrect system account"})
+	case err != nil:
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not transfer coins"})
+	default:
+		context.JSON(http.StatusOK, transaction)
+		return
+	}
+	context.Abort()
+}
@@ -4,23 +4,23 @@ import (
 	"avito/database"
 	"avito/models"
 	"errors"
-	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
 	"net/http"
+	"strconv"
 )
 
 func SendCoin(context *gin.Context) {
-	var user, sendTo models.User
-	var err error
+	var user models.User
 	var payload SendToPayload
 
-	if err = context.ShouldBindJSON(&payload); err != nil {
+	if err := context.ShouldBindJSON(&payload); err != nil {
 		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		context.Abort()
 		return
 	}
+	auditTarget(context, payload.ToUser)
 
 	if userId, ok := context.Get("user_id"); !ok {
 		context.JSON(http.StatusUnauthorized, ErrorResponse{Error: "Authorization failed"})
@@ -32,55 +32,93 @@ func SendCoin(context *gin.Context) {
 		return
 	}
 
-	if res := database.PostgresDB.Where("Username = ?", payload.ToUser).First(&sendTo); res.Error != nil {
-		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Incorrect receiver's username"})
+	if status, message := sendCoins(&user, payload); status != http.StatusOK {
+		context.JSON(status, ErrorResponse{Error: message})
 		context.Abort()
 		return
 	}
-	if sendTo.ID == user.ID {
-		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Incorrect receiver's username"})
-		context.Abort()
-		return
+	context.JSON(http.StatusOK, gin.H{})
+}
+
+// sendCoins moves coins from the user to payload.ToUser and returns the HTTP
+// status and error message of the outcome. It is shared by every transport.
+func sendCoins(user *models.User, payload SendToPayload) (int, string) {
+	var sendTo models.User
+
+	if res := database.PostgresDB.Where("Username = ?", payload.ToUser).First(&sendTo); res.Error != nil {
+		return http.StatusBadRequest, "Incorrect receiver's username"
+	}
+	if sendTo.ID == user.ID || sendTo.IsSystem() {
+		return http.StatusBadRequest, "Incorrect receiver's username"
 	}
 
 	if user.Balance < payload.Amount {
-		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Insufficient funds to complete the transaction"})
-		context.Abort()
-		return
+		return http.StatusBadRequest, "Insufficient funds to complete the transaction"
 	}
 
-	transaction := models.Transaction{SenderID: user.ID, ReceiverID: sendTo.ID, Amount: payload.Amount}
-	err = database.PostgresDB.Transaction(func(tx *gorm.DB) error {
-		if err = tx.Create(&transaction).Error; err != nil {
+	err := database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		if _, err := models.LockUsers(tx, user.ID, sendTo.ID); err != nil {
 			return err
 		}
-		result := tx.Model(&user).Update("Balance", user.Balance-payload.Amount)
-		if result.Error != nil {
-			return result.Error
-		}
-		if result.RowsAffected == 0 {
-			return fmt.Errorf("сould not update sender's balance")
-		}
-
-		result = tx.Model(&sendTo).Update("Balance", sendTo.Balance+payload.Amount)
-		if result.Error != nil {
-			return result.Error
-		}
-		if result.RowsAffected == 0 {
-			return fmt.Errorf("сould not update receiver's balance")
-		}
-		return nil
+		_, err := models.Transfer(tx, user, sendTo.ID, payload.Amount, payload.Memo, payload.Category)
+		return err
 	})
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == "23514" || errors.Is(err, gorm.ErrCheckConstraintViolated) {
-			context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Incorrect amount of coins to complete the transaction"})
-			context.Abort()
-			return
+			return http.StatusBadRequest, "Incorrect amount of coins to complete the transaction"
+		}
+		if errors.Is(err, models.ErrAccountFrozen) {
+			return http.StatusForbidden, "Account is frozen"
 		}
-		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not send coins"})
+		if errors.Is(err, models.ErrInsufficientFunds) {
+			return http.StatusBadRequest, "Insufficient funds to complete the transaction"
+		}
+		return http.StatusInternalServerError, "Could not send coins"
+	}
+	return http.StatusOK, ""
+}
+
+func ReverseTransaction(context *gin.Context) {
+	var payload ReversePayload
+	var reversal models.Transaction
+
+	if err := context.ShouldBindJSON(&payload); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
 		context.Abort()
 		return
 	}
-	context.JSON(http.StatusOK, gin.H{})
+	originalID, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Incorrect transaction id"})
+		context.Abort()
+		return
+	}
+	admin, ok := authorizedUser(context)
+	if !ok {
+		return
+	}
+
+	err = database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		reversal, err = models.ReverseTransaction(tx, uint(originalID), admin.ID, payload.Reason, payload.AllowNegative)
+		return err
+	})
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find transaction"})
+	case errors.Is(err, models.ErrAlreadyReversed), errors.As(err, &pgErr) && pgErr.Code == "23505":
+		context.JSON(http.StatusConflict, ErrorResponse{Error: "Transaction is already reversed"})
+	case errors.Is(err, models.ErrCannotReverseReverse):
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Reversal can not be reversed"})
+	case errors.Is(err, models.ErrReversalUnderfunded):
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Receiver does not have enough coins for reversal"})
+	case err != nil:
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not reverse transaction"})
+	default:
+		context.JSON(http.StatusOK, reversal)
+		return
+	}
+	context.Abort()
 }
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"avito/database"
+	"avito/models"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// CreateWebhook subscribes a URL to events. The secret is generated when it
+// is not given and is shown only in this response.
+func CreateWebhook(context *gin.Context) {
+	var payload WebhookPayload
+	if err := context.ShouldBindJSON(&payload); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+	if payload.Secret == "" {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not generate secret"})
+			context.Abort()
+			return
+		}
+		payload.Secret = hex.EncodeToString(random)
+	}
+
+	subscription := models.WebhookSubscription{
+		URL:         payload.URL,
+		Secret:      payload.Secret,
+		EventTypes:  payload.EventTypes,
+		Description: payload.Description,
+	}
+	if err := database.PostgresDB.Create(&subscription).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not create webhook"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusCreated, WebhookCreatedSchema{subscription, subscription.Secret})
+}
+
+func ListWebhooks(context *gin.Context) {
+	var subscriptions []models.WebhookSubscription
+	if err := database.PostgresDB.Order("id").Find(&subscriptions).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get webhooks"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, subscriptions)
+}
+
+// DeleteWebhook stops the subscription, its pending deliveries become dead
+// letters.
+func DeleteWebhook(context *gin.Context) {
+	res := database.PostgresDB.Where("id = ?", context.Param("id")).Delete(&models.WebhookSubscription{})
+	if res.Error != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not delete webhook"})
+		context.Abort()
+		return
+	}
+	if res.RowsAffected == 0 {
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find webhook"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, gin.H{})
+}
+
+// ListWebhookDeliveries shows deliveries from the newest, ?status=dead is
+// the dead letter view.
+func ListWebhookDeliveries(context *gin.Context) {
+	var query DeliveriesQuery
+	var deliveries []models.WebhookDelivery
+
+	if err := context.ShouldBindQuery(&query); err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		context.Abort()
+		return
+	}
+	if query.Limit == 0 {
+		query.Limit = 100
+	}
+	db := database.PostgresDB.Preload("Event")
+	if query.Status != "" {
+		db = db.Where("status = ?", query.Status)
+	}
+	if query.SubscriptionID != 0 {
+		db = db.Where("subscription_id = ?", query.SubscriptionID)
+	}
+	if err := db.Order("id desc").Limit(query.Limit).Offset(query.Offset).Find(&deliveries).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not get deliveries"})
+		context.Abort()
+		return
+	}
+	context.JSON(http.StatusOK, deliveries)
+}
+
+func RetryWebhookDelivery(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, ErrorResponse{Error: "Incorrect delivery id"})
+		context.Abort()
+		return
+	}
+	delivery, err := models.RetryWebhookDelivery(database.PostgresDB, uint(id), time.Now())
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		context.JSON(http.StatusNotFound, ErrorResponse{Error: "Could not find dead delivery"})
+	case err != nil:
+		context.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Could not retry delivery"})
+	default:
+		context.JSON(http.StatusOK, delivery)
+		return
+	}
+	context.Abort()
+}
@@ -9,15 +9,18 @@ import (
 
 var PostgresDB *gorm.DB
 
-func InitDatabase() error {
-
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
+func DSN() string {
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
 		config.Cfg.Database.Host,
 		config.Cfg.Database.Username,
 		config.Cfg.Database.Password,
 		config.Cfg.Database.DatabaseName,
 		config.Cfg.Database.Port)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+}
+
+func InitDatabase() error {
+
+	db, err := gorm.Open(postgres.Open(DSN()), &gorm.Config{})
 	if err != nil {
 		return err
 	}
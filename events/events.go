@@ -0,0 +1,105 @@
+package events
+
+import (
+	"avito/config"
+	"avito/models"
+	"context"
+	"encoding/json"
+	"github.com/jackc/pgx/v5"
+	"log"
+	"sync"
+	"time"
+)
+
+// Subscription receives the events of one user. Events are dropped rather
+// than block the listener when the subscriber does not keep up.
+type Subscription struct {
+	Events <-chan models.Event
+	userID uint
+	events chan models.Event
+}
+
+var (
+	mutex       sync.Mutex
+	subscribers = map[uint]map[*Subscription]struct{}{}
+)
+
+func Subscribe(userID uint) *Subscription {
+	buffer := config.Cfg.Events.Buffer
+	if buffer <= 0 {
+		buffer = 32
+	}
+	events := make(chan models.Event, buffer)
+	subscription := &Subscription{Events: events, userID: userID, events: events}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if subscribers[userID] == nil {
+		subscribers[userID] = map[*Subscription]struct{}{}
+	}
+	subscribers[userID][subscription] = struct{}{}
+	return subscription
+}
+
+func (subscription *Subscription) Close() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(subscribers[subscription.userID], subscription)
+	if len(subscribers[subscription.userID]) == 0 {
+		delete(subscribers, subscription.userID)
+	}
+}
+
+// Dispatch hands a notification payload to the subscribers of its user.
+func Dispatch(payload string) {
+	var event models.Event
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		log.Printf("[events] bad notification %q: %v", payload, err)
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	for subscription := range subscribers[event.UserID] {
+		select {
+		case subscription.events <- event:
+		default:
+			log.Printf("[events] subscriber of user %d is too slow, %s dropped", event.UserID, event.Type)
+		}
+	}
+}
+
+// Listen keeps a dedicated connection listening to models.EventsChannel and
+// reconnects after failures until ctx is done.
+func Listen(ctx context.Context, dsn string) {
+	for {
+		err := listen(ctx, dsn)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[events] listener stopped: %v, reconnecting", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func listen(ctx context.Context, dsn string) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+models.EventsChannel); err != nil {
+		return err
+	}
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		Dispatch(notification.Payload)
+	}
+}
@@ -1,73 +1,212 @@
 package main
 
 import (
+	"avito/catalog"
 	"avito/config"
 	"avito/controllers"
 	"avito/database"
+	"avito/events"
 	"avito/middleware"
 	"avito/models"
-	"encoding/json"
-	"errors"
+	"avito/relay"
+	"avito/scheduler"
+	"avito/shoppb"
+	"avito/webhooks"
+	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgx/v5/pgconn"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 	"gorm.io/gorm"
-	"io/ioutil"
+	"net"
+	"os"
 )
 
 func initRouter(api *gin.RouterGroup) {
 
+	api.Use(middleware.RequestID, middleware.Audit)
 	api.GET("/healthcheck", func(c *gin.Context) {})
 	api.POST("/auth", controllers.Auth)
+	api.GET("/items", controllers.ListItems)
+	api.GET("/items/:item", controllers.GetItem)
+	api.Static("/images", config.Cfg.Catalog.ImagesDir)
+	api.GET("/events", middleware.AuthenticateStream, controllers.StreamEvents)
+	api.GET("/live", middleware.AuthenticateStream, controllers.Live)
 	api.Use(middleware.Authenticate)
 	{
 		api.GET("/buy/:item", controllers.BuyItem)
 		api.POST("/sendCoin", controllers.SendCoin)
 		api.GET("/info", controllers.GetInfo)
+		api.GET("/statement", controllers.GetStatement)
+		api.GET("/purchases", controllers.ListPurchases)
+		api.POST("/purchases/:id/refund", controllers.RequestRefund)
+		api.GET("/refunds", controllers.ListRefunds)
+		api.GET("/payment-requests", controllers.ListPaymentRequests)
+		api.POST("/payment-requests", controllers.CreatePaymentRequest)
+		api.POST("/payment-requests/:id/accept", controllers.AcceptPaymentRequest)
+		api.POST("/payment-requests/:id/decline", controllers.DeclinePaymentRequest)
+		api.GET("/group-requests", controllers.ListGroupRequests)
+		api.POST("/group-requests", controllers.CreateGroupRequest)
+		api.GET("/group-requests/:id", controllers.GetGroupRequest)
+		api.POST("/group-requests/:id/pay", controllers.PayGroupRequest)
+		api.POST("/group-requests/:id/cancel", controllers.CancelGroupRequest)
+		api.GET("/scheduled-transfers", controllers.ListScheduledTransfers)
+		api.POST("/scheduled-transfers", controllers.CreateScheduledTransfer)
+		api.GET("/scheduled-transfers/:id/runs", controllers.ListScheduledTransferRuns)
+		api.DELETE("/scheduled-transfers/:id", controllers.CancelScheduledTransfer)
+	}
+	admin := api.Group("/admin", middleware.RequireAdmin)
+	{
+		admin.POST("/catalog/sync", controllers.SyncCatalog)
+		admin.PUT("/items/:item/stock", controllers.SetItemStock)
+		admin.GET("/promos", controllers.ListPromoCodes)
+		admin.POST("/promos", controllers.CreatePromoCode)
+		admin.DELETE("/promos/:code", controllers.DeletePromoCode)
+		admin.GET("/price-rules", controllers.ListPriceRules)
+		admin.POST("/price-rules", controllers.CreatePriceRule)
+		admin.DELETE("/price-rules/:id", controllers.EndPriceRule)
+		admin.GET("/price-rules/preview", controllers.PreviewPrices)
+		admin.GET("/refunds", controllers.ListAllRefunds)
+		admin.POST("/refunds/:id/approve", controllers.ApproveRefund)
+		admin.POST("/refunds/:id/reject", controllers.RejectRefund)
+		admin.POST("/transactions/:id/reverse", controllers.ReverseTransaction)
+		admin.GET("/accounts", controllers.ListSystemAccounts)
+		admin.POST("/accounts/transfer", controllers.TransferSystemCoins)
+		admin.POST("/grant", controllers.GrantCoins)
+		admin.POST("/burn", controllers.BurnCoins)
+		admin.POST("/users/:username/unfreeze", controllers.UnfreezeUser)
+		admin.GET("/reconciliation", controllers.GetReconciliation)
+		admin.POST("/reconciliation", controllers.RunReconciliation)
+		admin.GET("/metrics", controllers.Metrics)
+		admin.GET("/audit", controllers.ListAuditLogs)
+		admin.GET("/webhooks", controllers.ListWebhooks)
+		admin.POST("/webhooks", controllers.CreateWebhook)
+		admin.DELETE("/webhooks/:id", controllers.DeleteWebhook)
+		admin.GET("/webhooks/deliveries", controllers.ListWebhookDeliveries)
+		admin.POST("/webhooks/deliveries/:id/retry", controllers.RetryWebhookDelivery)
 	}
 }
 func MigrateDB() error {
-	if err := database.PostgresDB.AutoMigrate(&models.User{}, &models.Item{}, &models.Transaction{}, &models.Purchase{}); err != nil {
+	if err := database.PostgresDB.AutoMigrate(&models.User{}, &models.Item{}, &models.ItemVariant{}, &models.Transaction{},
+		&models.PromoCode{}, &models.PriceRule{}, &models.Purchase{}, &models.Refund{}, &models.PaymentRequest{},
+		&models.GroupRequest{}, &models.GroupShare{}, &models.ScheduledTransfer{}, &models.ScheduledTransferRun{},
+		&models.AllowanceGrant{}, &models.ReconciliationRun{}, &models.AuditLog{},
+		&models.OutboxEvent{}, &models.WebhookSubscription{}, &models.WebhookDelivery{}, &models.ChainAnchor{},
+		&models.RelayLease{}); err != nil {
 		return err
 	}
-	return nil
-}
-func LoadItems() error {
-	content, readErr := ioutil.ReadFile("data/items.json")
-	if readErr != nil {
-		return readErr
+	if err := models.MakeAuditLogAppendOnly(database.PostgresDB); err != nil {
+		return err
 	}
-	var items []models.Item
-	if err := json.Unmarshal(content, &items); err != nil {
+	if err := models.EnsureChainAnchors(database.PostgresDB); err != nil {
 		return err
 	}
-	for _, item := range items {
-		if err := database.PostgresDB.Create(&item).Error; err != nil {
-			var pgErr *pgconn.PgError
-			if !errors.Is(err, gorm.ErrDuplicatedKey) && (errors.As(err, &pgErr) && pgErr.Code != "23505") {
-				return err
-			}
+	// system accounts may go negative, the old constraint did not allow it
+	if database.PostgresDB.Migrator().HasConstraint(&models.User{}, "chk_users_balance") {
+		if err := database.PostgresDB.Migrator().DropConstraint(&models.User{}, "chk_users_balance"); err != nil {
+			return err
+		}
+	}
+	// the unique constraint on promo codes is replaced by idx_promo_code_active
+	if database.PostgresDB.Migrator().HasIndex(&models.PromoCode{}, "idx_promo_code") {
+		if err := database.PostgresDB.Migrator().DropIndex(&models.PromoCode{}, "idx_promo_code"); err != nil {
+			return err
 		}
 	}
+	if err := models.EnsureSystemAccounts(database.PostgresDB); err != nil {
+		return err
+	}
+	// welcome coins were first booked as grants, the ledger counts those twice;
+	// such rows predate the hash chain, so their hash does not change
+	if err := database.PostgresDB.Model(&models.Transaction{}).
+		Where("category = ? AND memo = ? AND coalesce(hash, '') = '' AND sender_id IN (?)",
+			models.CategoryGrant, models.WelcomeMemo, database.PostgresDB.Model(&models.User{}).Select("id").
+				Where("username = ? AND role = ?", models.MintAccount, models.RoleSystem)).
+		UpdateColumn("category", models.CategoryWelcome).Error; err != nil {
+		return err
+	}
+	if err := models.OpenSupply(database.PostgresDB); err != nil {
+		return err
+	}
+	// purchases made before promo codes only stored the charged price
+	if err := database.PostgresDB.Model(&models.Purchase{}).
+		Where("list_price = 0 AND discount = 0 AND price > 0").
+		UpdateColumn("list_price", gorm.Expr("price")).Error; err != nil {
+		return err
+	}
 	return nil
 }
 
-func main() {
-	config.Cfg.Init()
-	if err := database.InitDatabase(); err != nil {
-		panic(err)
-	}
+func serve() error {
 	if err := MigrateDB(); err != nil {
-		panic(err)
+		return err
 	}
-	if err := LoadItems(); err != nil {
-		panic(err)
+	if _, err := catalog.Sync(config.Cfg.Catalog.Path, false); err != nil {
+		return err
+	}
+	if config.Cfg.Catalog.WatchInterval > 0 {
+		go catalog.Watch(config.Cfg.Catalog.Path, config.Cfg.Catalog.WatchInterval)
+	}
+	if config.Cfg.Schedule.Interval > 0 {
+		go scheduler.Run(config.Cfg.Schedule.Interval)
+	}
+	go events.Listen(context.Background(), database.DSN())
+	if config.Cfg.Webhooks.Interval > 0 {
+		go webhooks.Run(config.Cfg.Webhooks.Interval)
+	}
+	sink, err := relay.NewSink()
+	if err != nil {
+		return err
 	}
-	r := gin.Default()
+	if sink != nil && config.Cfg.Relay.Interval > 0 {
+		go relay.Run(sink, config.Cfg.Relay.Interval)
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%s", config.Cfg.Server.GRPCPort))
+	if err != nil {
+		return fmt.Errorf("failed to listen for gRPC due to: %w", err)
+	}
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(middleware.AuditRPC, middleware.AuthenticateRPC))
+	shoppb.RegisterShopServiceServer(server, controllers.ShopService{})
+	reflection.Register(server)
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			fmt.Fprintf(os.Stderr, "[Error] gRPC server stopped: %v\n", err)
+		}
+	}()
+
+	r := gin.New()
+	r.Use(middleware.Logger(), gin.Recovery())
 	api := r.Group("/api")
 	initRouter(api)
 
 	if err := r.Run(fmt.Sprintf(":%s", config.Cfg.Server.Port)); err != nil {
-		panic("[Error] failed to start Gin server due to: " + err.Error())
+		return fmt.Errorf("failed to start Gin server due to: %w", err)
+	}
+	return nil
+}
+
+func main() {
+	name, args := "serve", []string{}
+	if len(os.Args) > 1 {
+		name, args = os.Args[1], os.Args[2:]
+	}
+	if name == "help" || name == "-h" || name == "--help" {
+		usage()
+		return
+	}
+	command, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	config.Cfg.Init()
+	if err := database.InitDatabase(); err != nil {
+		panic(err)
+	}
+	if err := command.run(args); err != nil {
+		fmt.Fprintf(os.Stderr, "[Error] %s: %v\n", name, err)
+		os.Exit(1)
 	}
 }
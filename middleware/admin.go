@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"avito/controllers"
+	"avito/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+func RequireAdmin(context *gin.Context) {
+	userId, ok := context.Get("user_id")
+	if !ok {
+		context.JSON(http.StatusUnauthorized, controllers.ErrorResponse{Error: "Authorization failed"})
+		context.Abort()
+		return
+	}
+	user, err := models.GetUserByID(userId)
+	if err != nil {
+		context.JSON(http.StatusUnauthorized, controllers.ErrorResponse{Error: "Authorization failed"})
+		context.Abort()
+		return
+	}
+	if !user.IsAdmin() {
+		context.JSON(http.StatusForbidden, controllers.ErrorResponse{Error: "Admin rights required"})
+		context.Abort()
+		return
+	}
+	context.Next()
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"avito/controllers"
+	"avito/database"
+	"avito/models"
+	"bytes"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const auditDetailLimit = 500
+
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (writer *auditWriter) Write(data []byte) (int, error) {
+	if writer.Status() >= http.StatusBadRequest && writer.body.Len() < auditDetailLimit {
+		writer.body.Write(data)
+	}
+	return writer.ResponseWriter.Write(data)
+}
+
+// Audit records every state-changing request after it is handled: all
+// non-GET requests and GET requests whose handler named an audit target.
+func Audit(context *gin.Context) {
+	writer := &auditWriter{ResponseWriter: context.Writer}
+	context.Writer = writer
+	context.Next()
+
+	target, targeted := context.Get(controllers.AuditTargetKey)
+	if context.Request.Method == http.MethodGet && !targeted {
+		return
+	}
+	entry := models.AuditLog{
+		Action:    context.Request.Method + " " + context.FullPath(),
+		Actor:     context.GetString(controllers.AuditActorKey),
+		RequestID: context.GetString("request_id"),
+		IP:        context.ClientIP(),
+		Status:    writer.Status(),
+		Outcome:   models.AuditSuccess,
+	}
+	if userID, ok := context.Get("user_id"); ok {
+		if id, ok := userID.(uint); ok {
+			entry.ActorID = &id
+		}
+	}
+	if targeted {
+		entry.Target = fmt.Sprint(target)
+	} else {
+		params := make([]string, 0, len(context.Params))
+		for _, param := range context.Params {
+			params = append(params, param.Key+"="+param.Value)
+		}
+		entry.Target = strings.Join(params, ",")
+	}
+	if entry.Status >= http.StatusBadRequest {
+		entry.Outcome = models.AuditFailure
+		entry.Detail = writer.body.String()
+		if len(entry.Detail) > auditDetailLimit {
+			entry.Detail = entry.Detail[:auditDetailLimit]
+		}
+	}
+	if err := models.WriteAudit(database.PostgresDB, &entry); err != nil {
+		log.Printf("[audit] could not write %s by %v: %v", entry.Action, entry.ActorID, err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"time"
 )
 
 func Authenticate(context *gin.Context) {
@@ -25,5 +26,15 @@ func Authenticate(context *gin.Context) {
 	}
 
 	context.Set("user_id", claims.UserID)
+	context.Set("token_expires_at", time.Unix(claims.ExpiresAt, 0))
 	context.Next()
 }
+
+// AuthenticateStream also accepts the token in the access_token query
+// parameter, since browsers can not set headers on an EventSource.
+func AuthenticateStream(context *gin.Context) {
+	if context.Request.Header.Get("Authorization") == "" {
+		context.Request.Header.Set("Authorization", context.Query("access_token"))
+	}
+	Authenticate(context)
+}
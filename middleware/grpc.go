@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"avito/controllers"
+	"avito/database"
+	"avito/models"
+	"avito/shoppb"
+	"avito/token"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"log"
+	"net"
+	"strings"
+)
+
+// publicRPC lists the methods that are open without a token, like their
+// HTTP counterparts.
+var publicRPC = map[string]bool{
+	shoppb.ShopService_Auth_FullMethodName:      true,
+	shoppb.ShopService_ListItems_FullMethodName: true,
+}
+
+func rpcMetadata(ctx context.Context, key string) string {
+	values := metadata.ValueFromIncomingContext(ctx, key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// rpcToken reads the token from the authorization metadata. The Bearer
+// prefix that gRPC clients usually add is optional.
+func rpcToken(ctx context.Context) string {
+	return strings.TrimPrefix(rpcMetadata(ctx, "authorization"), "Bearer ")
+}
+
+// AuthenticateRPC is the gRPC counterpart of Authenticate.
+func AuthenticateRPC(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	if publicRPC[info.FullMethod] {
+		return handler(ctx, request)
+	}
+	clientToken := rpcToken(ctx)
+	if clientToken == "" {
+		return nil, status.Error(codes.Unauthenticated, "No authorization header provided")
+	}
+	claims, err := token.ValidateToken(clientToken)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return handler(controllers.WithRPCUser(ctx, claims.UserID), request)
+}
+
+// rpcAuditTarget names the target of the state-changing methods, the other
+// methods are not audited.
+func rpcAuditTarget(request interface{}) (string, bool) {
+	switch request := request.(type) {
+	case *shoppb.AuthRequest:
+		return request.GetUsername(), true
+	case *shoppb.SendCoinRequest:
+		return request.GetToUser(), true
+	case *shoppb.BuyItemRequest:
+		return request.GetItem(), true
+	}
+	return "", false
+}
+
+// AuditRPC is the gRPC counterpart of RequestID and Audit. It runs before
+// AuthenticateRPC so rejected calls are recorded too, and stores the gRPC
+// code of the result as the status.
+func AuditRPC(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	requestID := rpcMetadata(ctx, "x-request-id")
+	if requestID == "" || len(requestID) > 64 {
+		requestID = newRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", requestID))
+
+	response, err := handler(ctx, request)
+
+	target, audited := rpcAuditTarget(request)
+	if !audited {
+		return response, err
+	}
+	result := status.Convert(err)
+	entry := models.AuditLog{
+		Action:    "gRPC " + info.FullMethod,
+		Target:    target,
+		RequestID: requestID,
+		Status:    int(result.Code()),
+		Outcome:   models.AuditSuccess,
+	}
+	if auth, ok := request.(*shoppb.AuthRequest); ok {
+		entry.Actor = auth.GetUsername()
+	} else if claims, err := token.ValidateToken(rpcToken(ctx)); err == nil {
+		entry.ActorID = &claims.UserID
+	}
+	if client, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(client.Addr.String()); err == nil {
+			entry.IP = host
+		}
+	}
+	if err != nil {
+		entry.Outcome = models.AuditFailure
+		entry.Detail = result.Message()
+		if len(entry.Detail) > auditDetailLimit {
+			entry.Detail = entry.Detail[:auditDetailLimit]
+		}
+	}
+	if err := models.WriteAudit(database.PostgresDB, &entry); err != nil {
+		log.Printf("[audit] could not write %s by %v: %v", entry.Action, entry.ActorID, err)
+	}
+	return response, err
+}
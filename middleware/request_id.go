@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID keeps the request id sent by the client or generates one, and
+// returns it in the response header.
+func RequestID(context *gin.Context) {
+	requestID := context.GetHeader(RequestIDHeader)
+	if requestID == "" || len(requestID) > 64 {
+		requestID = newRequestID()
+	}
+	context.Set("request_id", requestID)
+	context.Header(RequestIDHeader, requestID)
+	context.Next()
+}
+
+func newRequestID() string {
+	random := make([]byte, 16)
+	rand.Read(random)
+	return hex.EncodeToString(random)
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+const (
+	AllowanceDaily   = "daily"
+	AllowanceWeekly  = "weekly"
+	AllowanceMonthly = "monthly"
+)
+
+// AllowancePolicy describes the periodic top-up: the amount per role for
+// every period and the balance the top-up never goes above.
+type AllowancePolicy struct {
+	Period     string
+	Amounts    map[string]float32
+	MaxBalance float32
+}
+
+// AllowanceGrant marks the user as topped up for the period, the unique
+// period key keeps a period from being granted twice. A grant that failed
+// keeps RetryAt until it is tried again.
+type AllowanceGrant struct {
+	gorm.Model
+	ID            uint         `gorm:"primary_key" autoIncrement:"true"`
+	UserID        uint         `gorm:"uniqueIndex:idx_allowance_grant_period;not null" json:"user_id"`
+	User          User         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:UserID" json:"-"`
+	Period        string       `gorm:"uniqueIndex:idx_allowance_grant_period;not null" json:"period"`
+	Amount        float32      `gorm:"not null" json:"amount"`
+	TransactionID *uint        `json:"transaction_id"`
+	Transaction   *Transaction `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:TransactionID" json:"-"`
+	Failures      int          `gorm:"not null;default:0" json:"failures"`
+	RetryAt       *time.Time   `json:"retry_at"`
+	LastError     string       `json:"last_error"`
+}
+
+// AllowanceRetry delays the grant of a user whose top-up failed, so that the
+// users after them are still paid.
+var AllowanceRetry = Backoff{Initial: time.Minute, Max: time.Hour}
+
+func (policy AllowancePolicy) Enabled() bool {
+	for _, amount := range policy.Amounts {
+		if amount > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// PeriodKey names the period now belongs to, e.g. 2025-03 for monthly.
+func (policy AllowancePolicy) PeriodKey(now time.Time) string {
+	switch policy.Period {
+	case AllowanceDaily:
+		return now.Format("2006-01-02")
+	case AllowanceWeekly:
+		year, week := now.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	default:
+		return now.Format("2006-01")
+	}
+}
+
+// TopUp returns how much the user gets for the period given the balance.
+func (policy AllowancePolicy) TopUp(user User) float32 {
+	amount := policy.Amounts[user.Role]
+	if policy.MaxBalance > 0 && user.Balance-user.Debt+amount > policy.MaxBalance {
+		amount = policy.MaxBalance - (user.Balance - user.Debt)
+	}
+	if amount < 0 {
+		return 0
+	}
+	return amount
+}
+
+// GrantAllowance tops up the oldest user not yet granted for the period in its
+// own tx and reports whether there was one. Frozen users are skipped until
+// they are unfrozen. A top-up that fails is rolled back and retried later,
+// see failAllowance.
+func GrantAllowance(db *gorm.DB, policy AllowancePolicy, now time.Time) (bool, error) {
+	period := policy.PeriodKey(now)
+	var user User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("role <> ? AND NOT frozen AND id NOT IN (?)", RoleSystem,
+				tx.Model(&AllowanceGrant{}).Select("user_id").
+					Where("period = ? AND (retry_at IS NULL OR retry_at > ?)", period, now)).
+			Order("id").Limit(1).Find(&user)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		// a failed grant of the period is taken over and cleared
+		grant := AllowanceGrant{UserID: user.ID, Period: period, Amount: policy.TopUp(user)}
+		res = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "period"}},
+			Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "allowance_grants.retry_at IS NOT NULL"}}},
+			DoUpdates: clause.AssignmentColumns([]string{"updated_at", "amount", "failures", "retry_at", "last_error"}),
+		}).Create(&grant)
+		if res.Error != nil || res.RowsAffected == 0 || grant.Amount == 0 {
+			return res.Error
+		}
+		transaction, err := Mint(tx, user.ID, grant.Amount, "allowance "+period, CategoryAllowance)
+		if err != nil {
+			return err
+		}
+		grant.TransactionID = &transaction.ID
+		return tx.Model(&grant).Select("transaction_id").Updates(&grant).Error
+	})
+	if err != nil && user.ID != 0 {
+		return true, failAllowance(db, user.ID, period, err, now)
+	}
+	return user.ID != 0, err
+}
+
+// failAllowance records the error of the user's grant and postpones the next
+// attempt with a growing delay.
+func failAllowance(db *gorm.DB, userID uint, period string, cause error, now time.Time) error {
+	var grant AllowanceGrant
+	if err := db.Where("user_id = ? AND period = ?", userID, period).Limit(1).Find(&grant).Error; err != nil {
+		return err
+	}
+	grant.UserID, grant.Period = userID, period
+	grant.Failures++
+	retryAt := now.Add(AllowanceRetry.Delay(grant.Failures))
+	grant.RetryAt = &retryAt
+	grant.LastError = cause.Error()
+	return db.Save(&grant).Error
+}
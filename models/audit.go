@@ -0,0 +1,47 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditLog is an append-only record of a state-changing action. It has no
+// foreign key to users so that entries outlive the accounts they mention.
+type AuditLog struct {
+	ID        uint      `gorm:"primary_key" autoIncrement:"true" json:"id"`
+	CreatedAt time.Time `gorm:"index:idx_audit_log_created;not null" json:"created_at"`
+	ActorID   *uint     `gorm:"index:idx_audit_log_actor" json:"actor_id"`
+	Actor     string    `json:"actor"`
+	Action    string    `gorm:"index:idx_audit_log_action;not null" json:"action"`
+	Target    string    `json:"target"`
+	RequestID string    `gorm:"index:idx_audit_log_request" json:"request_id"`
+	IP        string    `json:"ip"`
+	Outcome   string    `gorm:"not null" json:"outcome"`
+	Status    int       `json:"status"`
+	Detail    string    `json:"detail"`
+}
+
+const auditAppendOnlySQL = `
+CREATE OR REPLACE FUNCTION audit_logs_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_logs is append-only';
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS audit_logs_append_only ON audit_logs;
+CREATE TRIGGER audit_logs_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_logs
+	FOR EACH STATEMENT EXECUTE FUNCTION audit_logs_append_only();`
+
+// MakeAuditLogAppendOnly installs the trigger that rejects any change to
+// written audit entries.
+func MakeAuditLogAppendOnly(db *gorm.DB) error {
+	return db.Exec(auditAppendOnlySQL).Error
+}
+
+func WriteAudit(db *gorm.DB, entry *AuditLog) error {
+	return db.Create(entry).Error
+}
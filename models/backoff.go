@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Backoff doubles the delay after every failed attempt, starting at Initial
+// and never going above Max.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+func (backoff Backoff) Delay(attempts int) time.Duration {
+	delay := backoff.Initial
+	for i := 1; i < attempts && delay < backoff.Max; i++ {
+		delay *= 2
+	}
+	if delay > backoff.Max {
+		delay = backoff.Max
+	}
+	return delay
+}
@@ -0,0 +1,251 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+// Ledger rows are chained: every new transaction and purchase stores the
+// hash of the previous row of its table and a hash of its own content that
+// covers it. Rows written before the chain existed have no hash and are
+// skipped; the chain anchor keeps where they end, so a hash wiped later is
+// still noticed. Fields that legitimately change later (returned_at,
+// updated_at) are not hashed, and ledger rows are never deleted.
+
+const (
+	transactionChainLock = 4401
+	purchaseChainLock    = 4402
+)
+
+const (
+	ChainContentChanged = "content changed"
+	ChainLinkBroken     = "previous entry changed or removed"
+	ChainHashMissing    = "hash missing"
+	ChainEntryDeleted   = "entry deleted"
+)
+
+var chainTables = []string{"transactions", "purchases"}
+
+// ChainAnchor records the first id of a table whose rows must carry a hash.
+type ChainAnchor struct {
+	Name      string `gorm:"primaryKey" json:"name"`
+	FirstID   uint   `gorm:"not null" json:"first_id"`
+	CreatedAt time.Time
+}
+
+var errChainBroken = errors.New("chain is broken")
+
+type ChainReport struct {
+	Table    string `json:"table"`
+	Checked  int    `json:"checked"`
+	Legacy   int    `json:"legacy"`
+	FirstID  uint   `json:"first_id"`
+	HeadID   uint   `json:"head_id"`
+	HeadHash string `json:"head_hash"`
+	BrokenID *uint  `json:"broken_id"`
+	Problem  string `json:"problem,omitempty"`
+}
+
+func (report ChainReport) Intact() bool {
+	return report.BrokenID == nil
+}
+
+func chainHash(prev string, fields ...interface{}) string {
+	parts := make([]string, 0, len(fields)+1)
+	parts = append(parts, prev)
+	for _, field := range fields {
+		switch value := field.(type) {
+		case *uint:
+			if value == nil {
+				parts = append(parts, "")
+			} else {
+				parts = append(parts, fmt.Sprint(*value))
+			}
+		case time.Time:
+			parts = append(parts, value.UTC().Format(time.RFC3339Nano))
+		default:
+			parts = append(parts, fmt.Sprint(value))
+		}
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:])
+}
+
+// chainHead locks the chain of the table until the end of the database
+// transaction and returns the hash of its last row, empty for a legacy row.
+// Balances are updated before the insert, so user rows are always locked
+// before the chain.
+func chainHead(tx *gorm.DB, table string, lock int64) (string, error) {
+	var head sql.NullString
+	db := tx.Session(&gorm.Session{NewDB: true})
+	if err := db.Exec("SELECT pg_advisory_xact_lock(?)", lock).Error; err != nil {
+		return "", err
+	}
+	err := db.Raw("SELECT hash FROM " + table + " ORDER BY id DESC LIMIT 1").Scan(&head).Error
+	return head.String, err
+}
+
+// Postgres keeps microseconds, so the hashed time is cut to match what is
+// read back. The row is created with updated_at equal to it, reconciliation
+// treats any difference as a later change.
+func chainTime(createdAt time.Time) time.Time {
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	return createdAt.Truncate(time.Microsecond)
+}
+
+func (transaction *Transaction) contentHash() string {
+	return chainHash(transaction.PrevHash, transaction.CreatedAt, transaction.SenderID, transaction.ReceiverID,
+		transaction.Amount, transaction.ReversalOfID, transaction.ReversedByID, transaction.Reason, transaction.Memo, transaction.Category)
+}
+
+func (transaction *Transaction) BeforeCreate(tx *gorm.DB) error {
+	prev, err := chainHead(tx, "transactions", transactionChainLock)
+	if err != nil {
+		return err
+	}
+	transaction.CreatedAt = chainTime(transaction.CreatedAt)
+	transaction.UpdatedAt = transaction.CreatedAt
+	transaction.PrevHash = prev
+	transaction.Hash = transaction.contentHash()
+	return nil
+}
+
+func (purchase *Purchase) contentHash() string {
+	return chainHash(purchase.PrevHash, purchase.CreatedAt, purchase.UserID, purchase.ItemID, purchase.VariantID,
+		purchase.Price, purchase.ListPrice, purchase.Discount, purchase.PromoCodeID, purchase.PriceRuleID)
+}
+
+func (purchase *Purchase) BeforeCreate(tx *gorm.DB) error {
+	prev, err := chainHead(tx, "purchases", purchaseChainLock)
+	if err != nil {
+		return err
+	}
+	purchase.CreatedAt = chainTime(purchase.CreatedAt)
+	purchase.UpdatedAt = purchase.CreatedAt
+	purchase.PrevHash = prev
+	purchase.Hash = purchase.contentHash()
+	return nil
+}
+
+// EnsureChainAnchors anchors the chain of every table that has no anchor yet
+// at its first hashed row, or at the next row when none is hashed.
+func EnsureChainAnchors(db *gorm.DB) error {
+	for _, table := range chainTables {
+		var firstID uint
+		err := db.Raw("SELECT coalesce((SELECT min(id) FROM " + table + " WHERE coalesce(hash, '') <> ''), " +
+			"(SELECT coalesce(max(id), 0) + 1 FROM " + table + "))").Scan(&firstID).Error
+		if err != nil {
+			return err
+		}
+		anchor := ChainAnchor{Name: table, FirstID: firstID}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&anchor).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type chainVerifier struct {
+	report  ChainReport
+	started bool
+}
+
+// check compares one row, in id order, with the row before it and returns
+// false at the first broken link. Rows without a hash are legacy only before
+// the anchor.
+func (verifier *chainVerifier) check(id uint, deleted bool, prev string, hash string, content string) bool {
+	if hash == "" && !deleted && !verifier.started && id < verifier.report.FirstID {
+		verifier.report.Legacy++
+		return true
+	}
+	verifier.started = true
+	problem := ""
+	switch {
+	case deleted:
+		problem = ChainEntryDeleted
+	case hash == "":
+		problem = ChainHashMissing
+	case prev != verifier.report.HeadHash:
+		problem = ChainLinkBroken
+	case hash != content:
+		problem = ChainContentChanged
+	}
+	if problem != "" {
+		verifier.report.BrokenID = &id
+		verifier.report.Problem = problem
+		return false
+	}
+	verifier.report.Checked++
+	verifier.report.HeadID = id
+	verifier.report.HeadHash = hash
+	return true
+}
+
+func verifyTransactions(db *gorm.DB, firstID uint) (ChainReport, error) {
+	var batch []Transaction
+	verifier := chainVerifier{report: ChainReport{Table: "transactions", FirstID: firstID}}
+	err := db.Unscoped().FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+		for _, transaction := range batch {
+			if !verifier.check(transaction.ID, transaction.DeletedAt.Valid, transaction.PrevHash, transaction.Hash,
+				transaction.contentHash()) {
+				return errChainBroken
+			}
+		}
+		return nil
+	}).Error
+	if err == errChainBroken {
+		err = nil
+	}
+	return verifier.report, err
+}
+
+func verifyPurchases(db *gorm.DB, firstID uint) (ChainReport, error) {
+	var batch []Purchase
+	verifier := chainVerifier{report: ChainReport{Table: "purchases", FirstID: firstID}}
+	err := db.Unscoped().FindInBatches(&batch, 1000, func(tx *gorm.DB, _ int) error {
+		for _, purchase := range batch {
+			if !verifier.check(purchase.ID, purchase.DeletedAt.Valid, purchase.PrevHash, purchase.Hash,
+				purchase.contentHash()) {
+				return errChainBroken
+			}
+		}
+		return nil
+	}).Error
+	if err == errChainBroken {
+		err = nil
+	}
+	return verifier.report, err
+}
+
+// VerifyChains walks the transaction and purchase chains from the first
+// row and reports where each one breaks. A table without an anchor must be
+// hashed from its first row. A row deleted from the end of a chain can only
+// be noticed by comparing the head with an earlier report.
+func VerifyChains(db *gorm.DB) ([]ChainReport, error) {
+	var anchors []ChainAnchor
+	if err := db.Find(&anchors).Error; err != nil {
+		return nil, err
+	}
+	firstIDs := map[string]uint{}
+	for _, anchor := range anchors {
+		firstIDs[anchor.Name] = anchor.FirstID
+	}
+	transactions, err := verifyTransactions(db, firstIDs["transactions"])
+	if err != nil {
+		return nil, err
+	}
+	purchases, err := verifyPurchases(db, firstIDs["purchases"])
+	if err != nil {
+		return nil, err
+	}
+	return []ChainReport{transactions, purchases}, nil
+}
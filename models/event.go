@@ -0,0 +1,101 @@
+package models
+
+import (
+	"encoding/json"
+	"gorm.io/gorm"
+)
+
+// EventsChannel is the Postgres NOTIFY channel user events are sent to. A
+// notification is delivered to listeners only when the transaction that
+// published it commits, so every replica sees exactly the committed events.
+// The events are also kept in the outbox for consumers that must not lose
+// them.
+const EventsChannel = "shop_events"
+
+const (
+	EventCoinReceived           = "coin_received"
+	EventCoinSent               = "coin_sent"
+	EventPurchaseCompleted      = "purchase_completed"
+	EventPaymentRequested       = "payment_request_created"
+	EventPaymentRequestAccepted = "payment_request_accepted"
+	EventPaymentRequestDeclined = "payment_request_declined"
+)
+
+// Domain events are written to the outbox for every created transaction
+// and purchase, whatever code path creates them. They are not sent to users.
+const (
+	EventTransactionCreated = "transaction_created"
+	EventPurchaseCreated    = "purchase_created"
+)
+
+// EventTypes are the types consumers may subscribe to.
+var EventTypes = []string{EventCoinReceived, EventCoinSent, EventPurchaseCompleted, EventPaymentRequested,
+	EventPaymentRequestAccepted, EventPaymentRequestDeclined, EventTransactionCreated, EventPurchaseCreated}
+
+type Event struct {
+	ID     uint                   `json:"id"`
+	Type   string                 `json:"type"`
+	UserID uint                   `json:"user_id"`
+	Data   map[string]interface{} `json:"data"`
+}
+
+func Publish(tx *gorm.DB, event Event) error {
+	record, err := recordEvent(tx, event)
+	if err != nil {
+		return err
+	}
+	event.ID = record.ID
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return tx.Exec("SELECT pg_notify(?, ?)", EventsChannel, string(payload)).Error
+}
+
+func coinReceived(transaction Transaction, from string) Event {
+	return Event{Type: EventCoinReceived, UserID: transaction.ReceiverID, Data: map[string]interface{}{
+		"transaction_id": transaction.ID,
+		"from_user":      from,
+		"amount":         transaction.Amount,
+		"memo":           transaction.Memo,
+		"category":       transaction.Category,
+	}}
+}
+
+// refundReceived tells the user about the coins of an approved refund, they
+// are credited with no transaction, so the refund id is given instead.
+func refundReceived(refund *Refund) Event {
+	return Event{Type: EventCoinReceived, UserID: refund.UserID, Data: map[string]interface{}{
+		"refund_id":   refund.ID,
+		"purchase_id": refund.PurchaseID,
+		"from_user":   ShopAccount,
+		"amount":      refund.Amount,
+		"memo":        refund.Comment,
+	}}
+}
+
+func coinSent(transaction Transaction) Event {
+	return Event{Type: EventCoinSent, UserID: transaction.SenderID, Data: map[string]interface{}{
+		"transaction_id": transaction.ID,
+		"receiver_id":    transaction.ReceiverID,
+		"amount":         transaction.Amount,
+		"memo":           transaction.Memo,
+		"category":       transaction.Category,
+	}}
+}
+
+func paymentRequestEvent(eventType string, userID uint, request *PaymentRequest) Event {
+	return Event{Type: eventType, UserID: userID, Data: map[string]interface{}{
+		"payment_request_id": request.ID,
+		"requester_id":       request.RequesterID,
+		"payer_id":           request.PayerID,
+		"amount":             request.Amount,
+		"memo":               request.Memo,
+		"status":             request.Status,
+	}}
+}
+
+// PaymentRequested tells the payer about a new payment request.
+func PaymentRequested(tx *gorm.DB, request *PaymentRequest) error {
+	return Publish(tx, paymentRequestEvent(EventPaymentRequested, request.PayerID, request))
+}
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math"
+	"time"
+)
+
+const (
+	GroupRequestOpen     = "open"
+	GroupRequestFunded   = "funded"
+	GroupRequestCanceled = "canceled"
+)
+
+var (
+	ErrSharesMismatch      = errors.New("shares do not add up to the total")
+	ErrGroupRequestClosed  = errors.New("group request is already closed")
+	ErrShareAlreadyPaid    = errors.New("share is already paid")
+	ErrGroupRequestHasPaid = errors.New("group request already has paid shares")
+)
+
+type GroupRequest struct {
+	gorm.Model
+	ID          uint         `gorm:"primary_key" autoIncrement:"true"`
+	RequesterID uint         `gorm:"index:idx_group_request_requester;not null" json:"requester_id"`
+	Requester   User         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:RequesterID" json:"-"`
+	Total       float32      `gorm:"check:total > 0; not null" json:"total"`
+	Collected   float32      `gorm:"check:collected >= 0; not null; default:0" json:"collected"`
+	Memo        string       `gorm:"size:200" json:"memo"`
+	Category    string       `json:"category"`
+	Status      string       `gorm:"index:idx_group_request_status;not null;default:open" json:"status"`
+	FundedAt    *time.Time   `json:"funded_at"`
+	Shares      []GroupShare `gorm:"foreignKey:GroupRequestID" json:"shares"`
+}
+
+type GroupShare struct {
+	gorm.Model
+	ID             uint         `gorm:"primary_key" autoIncrement:"true"`
+	GroupRequestID uint         `gorm:"uniqueIndex:idx_group_share_payer;not null" json:"group_request_id"`
+	PayerID        uint         `gorm:"uniqueIndex:idx_group_share_payer;not null" json:"payer_id"`
+	Payer          User         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:PayerID" json:"-"`
+	Amount         float32      `gorm:"check:amount > 0; not null" json:"amount"`
+	PaidAt         *time.Time   `json:"paid_at"`
+	TransactionID  *uint        `json:"transaction_id"`
+	Transaction    *Transaction `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:TransactionID" json:"-"`
+}
+
+// SplitShares returns the share of every participant. Without explicit
+// amounts the total is split evenly and the cents left over go to the first
+// participant, otherwise the amounts must add up to the total.
+func SplitShares(total float32, amounts []float32) ([]float32, error) {
+	if len(amounts) == 0 {
+		return nil, ErrSharesMismatch
+	}
+	explicit := 0
+	var sum float64
+	for _, amount := range amounts {
+		if amount > 0 {
+			explicit++
+			sum += float64(amount)
+		}
+	}
+	switch explicit {
+	case len(amounts):
+		if math.Abs(sum-float64(total)) > 0.005 {
+			return nil, ErrSharesMismatch
+		}
+		return amounts, nil
+	case 0:
+		cents := int64(math.Round(float64(total) * 100))
+		count := int64(len(amounts))
+		if cents < count {
+			return nil, ErrSharesMismatch
+		}
+		shares := make([]float32, len(amounts))
+		for i := range shares {
+			shares[i] = float32(cents/count) / 100
+		}
+		shares[0] = float32(cents/count+cents%count) / 100
+		return shares, nil
+	default:
+		return nil, ErrSharesMismatch
+	}
+}
+
+// LockGroupRequest loads a group request with its shares holding the row lock
+// of the request until tx ends.
+func LockGroupRequest(tx *gorm.DB, id interface{}) (GroupRequest, error) {
+	var request GroupRequest
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&request).Error
+	if err != nil {
+		return request, err
+	}
+	err = tx.Where("group_request_id = ?", request.ID).Order("id").Find(&request.Shares).Error
+	return request, err
+}
+
+// Pay transfers the share of the payer to the requester and closes the request
+// once every share is paid. It must run in the same tx as LockGroupRequest.
+func (request *GroupRequest) Pay(tx *gorm.DB, payerID uint, now time.Time) (*GroupShare, error) {
+	if request.Status != GroupRequestOpen {
+		return nil, ErrGroupRequestClosed
+	}
+	var share *GroupShare
+	unpaid := 0
+	for i := range request.Shares {
+		if request.Shares[i].PayerID == payerID {
+			share = &request.Shares[i]
+		}
+		if request.Shares[i].PaidAt == nil {
+			unpaid++
+		}
+	}
+	if share == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if share.PaidAt != nil {
+		return nil, ErrShareAlreadyPaid
+	}
+
+	users, err := LockUsers(tx, payerID, request.RequesterID)
+	if err != nil {
+		return nil, err
+	}
+	var payer User
+	for _, user := range users {
+		if user.ID == payerID {
+			payer = user
+		}
+	}
+	if payer.ID == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	transaction, err := Transfer(tx, &payer, request.RequesterID, share.Amount, request.Memo, request.Category)
+	if err != nil {
+		return nil, err
+	}
+
+	share.PaidAt = &now
+	share.TransactionID = &transaction.ID
+	if err := tx.Model(share).Select("paid_at", "transaction_id").Updates(share).Error; err != nil {
+		return nil, err
+	}
+	request.Collected += share.Amount
+	if unpaid == 1 {
+		request.Status = GroupRequestFunded
+		request.FundedAt = &now
+	}
+	if err := tx.Model(request).Select("collected", "status", "funded_at").Updates(request).Error; err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// Cancel closes an open request nobody has paid yet.
+func (request *GroupRequest) Cancel(tx *gorm.DB) error {
+	if request.Status != GroupRequestOpen {
+		return ErrGroupRequestClosed
+	}
+	for _, share := range request.Shares {
+		if share.PaidAt != nil {
+			return ErrGroupRequestHasPaid
+		}
+	}
+	request.Status = GroupRequestCanceled
+	return tx.Model(request).Select("status").Updates(request).Error
+}
+
+func (request *GroupRequest) Involves(userID uint) bool {
+	if request.RequesterID == userID {
+		return true
+	}
+	for _, share := range request.Shares {
+		if share.PayerID == userID {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,103 @@
 package models
 
 import (
+	"errors"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrSoldOut              = errors.New("item is sold out")
+	ErrPurchaseLimitReached = errors.New("purchase limit for this item is reached")
+)
+
 type Item struct {
 	gorm.Model
-	ID       uint    `gorm:"primary_key" autoIncrement:"true"`
-	ItemName string  `gorm:"index:idx_item;unique;not null;" json:"item_name" binding:"required"`
-	Price    float32 `gorm:"check:price >= 0"`
+	ID           uint              `gorm:"primary_key" autoIncrement:"true"`
+	ItemName     string            `gorm:"index:idx_item;unique;not null;" json:"item_name" binding:"required"`
+	Price        float32           `gorm:"check:price >= 0"`
+	Stock        *int              `gorm:"check:stock >= 0" json:"stock"`
+	PerUserLimit *int              `gorm:"check:per_user_limit > 0" json:"per_user_limit"`
+	Category     string            `gorm:"index:idx_item_category" json:"category"`
+	Tags         []string          `gorm:"serializer:json;type:jsonb" json:"tags"`
+	Title        map[string]string `gorm:"serializer:json;type:jsonb" json:"title"`
+	Description  map[string]string `gorm:"serializer:json;type:jsonb" json:"description"`
+	Images       []string          `gorm:"serializer:json;type:jsonb" json:"images"`
+	Variants     []ItemVariant     `gorm:"foreignKey:ItemID" json:"variants"`
+}
+
+const DefaultLocale = "en"
+
+// Localized picks the translation for locale, falling back to the default
+// locale and then to fallback.
+func Localized(values map[string]string, locale string, fallback string) string {
+	if value, ok := values[locale]; ok && value != "" {
+		return value
+	}
+	if value, ok := values[DefaultLocale]; ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// ItemInStockSQL is InStock for items rows.
+const ItemInStockSQL = `(items.stock IS NULL OR items.stock > 0) AND (NOT EXISTS (SELECT 1 FROM item_variants v
+	WHERE v.item_id = items.id AND v.deleted_at IS NULL) OR EXISTS (SELECT 1 FROM item_variants v
+	WHERE v.item_id = items.id AND v.deleted_at IS NULL AND (v.stock IS NULL OR v.stock > 0)))`
+
+func (item *Item) InStock() bool {
+	if item.Stock != nil && *item.Stock <= 0 {
+		return false
+	}
+	if len(item.Variants) == 0 {
+		return true
+	}
+	for _, variant := range item.Variants {
+		if variant.InStock() {
+			return true
+		}
+	}
+	return false
+}
+
+func (item *Item) PriceFor(variant *ItemVariant) float32 {
+	if variant != nil && variant.Price != nil {
+		return *variant.Price
+	}
+	return item.Price
+}
+
+// TakeFromStock atomically decrements the stock of a limited item inside tx.
+func (item *Item) TakeFromStock(tx *gorm.DB) error {
+	if item.Stock == nil {
+		return nil
+	}
+	result := tx.Model(&Item{}).Where("id = ? AND stock > 0", item.ID).
+		UpdateColumn("stock", gorm.Expr("stock - 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSoldOut
+	}
+	return nil
+}
+
+// CheckUserLimit locks the buyer's row so that concurrent purchases of the
+// same user are counted one after another.
+func (item *Item) CheckUserLimit(tx *gorm.DB, userID uint) error {
+	if item.PerUserLimit == nil {
+		return nil
+	}
+	if err := LockUser(tx, userID); err != nil {
+		return err
+	}
+	var bought int64
+	if err := tx.Model(&Purchase{}).Where("user_id = ? AND item_id = ? AND returned_at IS NULL", userID, item.ID).
+		Count(&bought).Error; err != nil {
+		return err
+	}
+	if bought >= int64(*item.PerUserLimit) {
+		return ErrPurchaseLimitReached
+	}
+	return nil
 }
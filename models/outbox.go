@@ -0,0 +1,162 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// outboxWriteLock is held shared by every tx writing events until it ends.
+// The relay takes it exclusively to wait out the writers in flight.
+const outboxWriteLock = 4403
+
+const outboxRelay = "outbox"
+
+// OutboxEvent is an event stored in the same transaction as the change it
+// describes, so consumers never see an event of a rolled back change and
+// never miss one of a committed change.
+type OutboxEvent struct {
+	ID         uint                   `gorm:"primary_key" autoIncrement:"true" json:"id"`
+	CreatedAt  time.Time              `json:"created_at"`
+	Type       string                 `gorm:"index:idx_outbox_event_type;not null" json:"type"`
+	UserID     uint                   `gorm:"index:idx_outbox_event_user" json:"user_id"`
+	Data       map[string]interface{} `gorm:"serializer:json;type:jsonb" json:"data"`
+	WebhooksAt *time.Time             `gorm:"index:idx_outbox_event_webhooks" json:"-"`
+	RelayedAt  *time.Time             `gorm:"index:idx_outbox_event_relayed" json:"-"`
+}
+
+// RelayLease lets one relay publish at a time. A relay claims it for the time
+// a batch may take to publish and drops it once the batch is marked; a lease
+// of a relay that died expires and is taken over.
+type RelayLease struct {
+	Name      string    `gorm:"primaryKey" json:"name"`
+	Holder    string    `gorm:"not null" json:"holder"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+}
+
+func (record *OutboxEvent) Event() Event {
+	return Event{ID: record.ID, Type: record.Type, UserID: record.UserID, Data: record.Data}
+}
+
+func recordEvent(tx *gorm.DB, event Event) (OutboxEvent, error) {
+	record := OutboxEvent{Type: event.Type, UserID: event.UserID, Data: event.Data}
+	db := tx.Session(&gorm.Session{NewDB: true})
+	if err := db.Exec("SELECT pg_advisory_xact_lock_shared(?)", outboxWriteLock).Error; err != nil {
+		return record, err
+	}
+	err := db.Create(&record).Error
+	return record, err
+}
+
+// outboxWatermark waits for the txs writing events to end and returns the
+// last event id. Ids are taken from a sequence before commit, so a later
+// committed id may still have an earlier one in flight; up to the watermark
+// none is.
+func outboxWatermark(db *gorm.DB) (uint, error) {
+	var watermark uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", outboxWriteLock).Error; err != nil {
+			return err
+		}
+		return tx.Model(&OutboxEvent{}).Select("coalesce(max(id), 0)").Scan(&watermark).Error
+	})
+	return watermark, err
+}
+
+func (transaction *Transaction) AfterCreate(tx *gorm.DB) error {
+	_, err := recordEvent(tx, Event{Type: EventTransactionCreated, UserID: transaction.SenderID,
+		Data: map[string]interface{}{
+			"transaction_id": transaction.ID,
+			"sender_id":      transaction.SenderID,
+			"receiver_id":    transaction.ReceiverID,
+			"amount":         transaction.Amount,
+			"memo":           transaction.Memo,
+			"category":       transaction.Category,
+			"reversal_of_id": transaction.ReversalOfID,
+		}})
+	return err
+}
+
+func (purchase *Purchase) AfterCreate(tx *gorm.DB) error {
+	_, err := recordEvent(tx, Event{Type: EventPurchaseCreated, UserID: purchase.UserID,
+		Data: map[string]interface{}{
+			"purchase_id":   purchase.ID,
+			"user_id":       purchase.UserID,
+			"item_id":       purchase.ItemID,
+			"variant_id":    purchase.VariantID,
+			"price":         purchase.Price,
+			"list_price":    purchase.ListPrice,
+			"discount":      purchase.Discount,
+			"promo_code_id": purchase.PromoCodeID,
+		}})
+	return err
+}
+
+// RelayEvents passes the next events not yet relayed to publish in id order
+// and marks them relayed when it succeeds. publish runs with no tx or lock
+// held, under a lease that keeps other relays out, so it must return before
+// the lease runs out. Relays stop at the outbox watermark, so an event is
+// never published before an earlier one. An event may be published again if
+// marking fails, so consumers must deduplicate by id. It returns how many
+// events were relayed, none while another relay holds the lease.
+func RelayEvents(db *gorm.DB, now time.Time, limit int, lease time.Duration,
+	publish func(events []OutboxEvent) error) (int, error) {
+	holder, err := claimRelay(db, now, lease)
+	if err != nil || holder == "" {
+		return 0, err
+	}
+	var events []OutboxEvent
+	watermark, err := outboxWatermark(db)
+	if err == nil {
+		err = db.Where("relayed_at IS NULL AND id <= ?", watermark).Order("id").Limit(limit).Find(&events).Error
+	}
+	if err == nil && len(events) > 0 {
+		err = publish(events)
+	}
+	if err != nil || len(events) == 0 {
+		if releaseErr := releaseRelay(db, holder); err == nil {
+			err = releaseErr
+		}
+		return 0, err
+	}
+
+	ids := make([]uint, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&OutboxEvent{}).Where("id IN ?", ids).Update("relayed_at", now).Error; err != nil {
+			return err
+		}
+		return releaseRelay(tx, holder)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// claimRelay takes the relay lease unless another relay holds it and returns
+// the holder to release it with, empty when the lease is taken.
+func claimRelay(db *gorm.DB, now time.Time, lease time.Duration) (string, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	claim := RelayLease{Name: outboxRelay, Holder: hex.EncodeToString(random), ExpiresAt: now.Add(lease)}
+	res := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "relay_leases.expires_at <= ?", Vars: []interface{}{now}}}},
+		DoUpdates: clause.AssignmentColumns([]string{"holder", "expires_at"}),
+	}).Create(&claim)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return "", res.Error
+	}
+	return claim.Holder, nil
+}
+
+func releaseRelay(db *gorm.DB, holder string) error {
+	return db.Where("name = ? AND holder = ?", outboxRelay, holder).Delete(&RelayLease{}).Error
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+const (
+	PaymentRequestPending  = "pending"
+	PaymentRequestAccepted = "accepted"
+	PaymentRequestDeclined = "declined"
+	PaymentRequestExpired  = "expired"
+)
+
+var (
+	ErrPaymentRequestClosed  = errors.New("payment request is already closed")
+	ErrPaymentRequestExpired = errors.New("payment request is expired")
+)
+
+type PaymentRequest struct {
+	gorm.Model
+	ID            uint         `gorm:"primary_key" autoIncrement:"true"`
+	RequesterID   uint         `gorm:"index:idx_payment_request_requester;not null" json:"requester_id"`
+	Requester     User         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:RequesterID" json:"-"`
+	PayerID       uint         `gorm:"index:idx_payment_request_payer;not null" json:"payer_id"`
+	Payer         User         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:PayerID" json:"-"`
+	Amount        float32      `gorm:"check:amount > 0; not null" json:"amount"`
+	Memo          string       `gorm:"size:200" json:"memo"`
+	Category      string       `json:"category"`
+	Status        string       `gorm:"index:idx_payment_request_status;not null;default:pending" json:"status"`
+	ExpiresAt     time.Time    `gorm:"not null" json:"expires_at"`
+	RespondedAt   *time.Time   `json:"responded_at"`
+	TransactionID *uint        `json:"transaction_id"`
+	Transaction   *Transaction `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:TransactionID" json:"-"`
+}
+
+// ExpirePaymentRequests closes pending requests whose time is over.
+func ExpirePaymentRequests(db *gorm.DB, now time.Time) error {
+	return db.Model(&PaymentRequest{}).
+		Where("status = ? AND expires_at <= ?", PaymentRequestPending, now).
+		Update("status", PaymentRequestExpired).Error
+}
+
+// LockPaymentRequest loads a request addressed to the payer holding its row
+// lock until tx ends.
+func LockPaymentRequest(tx *gorm.DB, id interface{}, payerID uint) (PaymentRequest, error) {
+	var request PaymentRequest
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND payer_id = ?", id, payerID).First(&request).Error
+	return request, err
+}
+
+func (request *PaymentRequest) respond(tx *gorm.DB, status string, now time.Time) error {
+	request.Status = status
+	request.RespondedAt = &now
+	if err := tx.Model(request).Select("status", "responded_at", "transaction_id").Updates(request).Error; err != nil {
+		return err
+	}
+	eventType := EventPaymentRequestDeclined
+	if status == PaymentRequestAccepted {
+		eventType = EventPaymentRequestAccepted
+	}
+	return Publish(tx, paymentRequestEvent(eventType, request.RequesterID, request))
+}
+
+func (request *PaymentRequest) checkOpen(now time.Time) error {
+	if request.Status != PaymentRequestPending {
+		return ErrPaymentRequestClosed
+	}
+	if !now.Before(request.ExpiresAt) {
+		return ErrPaymentRequestExpired
+	}
+	return nil
+}
+
+// Accept pays the request with a regular transfer from the payer to the
+// requester. It must run in the same tx as LockPaymentRequest.
+func (request *PaymentRequest) Accept(tx *gorm.DB, now time.Time) error {
+	if err := request.checkOpen(now); err != nil {
+		return err
+	}
+	users, err := LockUsers(tx, request.PayerID, request.RequesterID)
+	if err != nil {
+		return err
+	}
+	var payer User
+	for _, user := range users {
+		if user.ID == request.PayerID {
+			payer = user
+		}
+	}
+	if payer.ID == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	transaction, err := Transfer(tx, &payer, request.RequesterID, request.Amount, request.Memo, request.Category)
+	if err != nil {
+		return err
+	}
+	request.TransactionID = &transaction.ID
+	return request.respond(tx, PaymentRequestAccepted, now)
+}
+
+func (request *PaymentRequest) Decline(tx *gorm.DB, now time.Time) error {
+	if err := request.checkOpen(now); err != nil {
+		return err
+	}
+	return request.respond(tx, PaymentRequestDeclined, now)
+}
@@ -0,0 +1,133 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	// RuleSetPrice replaces the catalog price, the other kinds reuse the
+	// discount kinds of promo codes.
+	RuleSetPrice = "price"
+)
+
+type PriceRule struct {
+	gorm.Model
+	ID         uint       `gorm:"primary_key" autoIncrement:"true"`
+	Name       string     `gorm:"not null" json:"name" binding:"required"`
+	Kind       string     `gorm:"not null" json:"kind" binding:"required,oneof=percent fixed price"`
+	Value      float32    `gorm:"check:value >= 0; not null" json:"value" binding:"min=0"`
+	Items      []string   `gorm:"serializer:json;type:jsonb" json:"items"`
+	Categories []string   `gorm:"serializer:json;type:jsonb" json:"categories"`
+	StartsAt   time.Time  `gorm:"index:idx_price_rule_period;not null" json:"starts_at" binding:"required"`
+	EndsAt     *time.Time `gorm:"index:idx_price_rule_period" json:"ends_at"`
+}
+
+func (rule *PriceRule) ActiveAt(moment time.Time) bool {
+	return !moment.Before(rule.StartsAt) && (rule.EndsAt == nil || moment.Before(*rule.EndsAt))
+}
+
+func (rule *PriceRule) AppliesTo(item *Item) bool {
+	if len(rule.Items) == 0 && len(rule.Categories) == 0 {
+		return true
+	}
+	for _, name := range rule.Items {
+		if name == item.ItemName {
+			return true
+		}
+	}
+	for _, category := range rule.Categories {
+		if category == item.Category {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *PriceRule) Apply(listPrice float32) float32 {
+	var price float32
+	switch rule.Kind {
+	case DiscountPercent:
+		price = listPrice - listPrice*rule.Value/100
+	case DiscountFixed:
+		price = listPrice - rule.Value
+	case RuleSetPrice:
+		price = rule.Value
+	default:
+		price = listPrice
+	}
+	if price < 0 {
+		return 0
+	}
+	return price
+}
+
+// priceRuleSQL selects the rules of the outer items row active at the
+// moment, it takes the moment twice.
+const priceRuleSQL = `r.deleted_at IS NULL AND r.starts_at <= ? AND (r.ends_at IS NULL OR r.ends_at > ?)
+	AND (coalesce(r.items, 'null') IN ('null', '[]') AND coalesce(r.categories, 'null') IN ('null', '[]')
+		OR r.items @> jsonb_build_array(items.item_name) OR r.categories @> jsonb_build_array(items.category))`
+
+// itemPriceSQL resolves the price of items rows like ResolvePrice does for
+// an item without variant, so the catalog is filtered, sorted and paged in
+// the query. The price is available as prices.price.
+const itemPriceSQL = `CROSS JOIN LATERAL (SELECT coalesce((SELECT r.value FROM price_rules r
+	WHERE r.kind = '` + RuleSetPrice + `' AND ` + priceRuleSQL + `
+	ORDER BY r.starts_at DESC, r.id DESC LIMIT 1), items.price) AS base) base_prices
+CROSS JOIN LATERAL (SELECT least(base_prices.base, coalesce(min(greatest(0, CASE r.kind
+	WHEN '` + DiscountPercent + `' THEN base_prices.base - base_prices.base * r.value / 100
+	ELSE base_prices.base - r.value END)), base_prices.base)) AS price
+	FROM price_rules r WHERE r.kind IN ('` + DiscountPercent + `', '` + DiscountFixed + `') AND ` + priceRuleSQL + `) prices`
+
+func WithItemPrices(moment time.Time) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Joins(itemPriceSQL, moment, moment, moment, moment)
+	}
+}
+
+func GetPriceRulesAt(db *gorm.DB, moment time.Time) ([]PriceRule, error) {
+	var rules []PriceRule
+	err := db.Where("starts_at <= ? AND (ends_at IS NULL OR ends_at > ?)", moment, moment).
+		Order("id").Find(&rules).Error
+	return rules, err
+}
+
+// BasePrice returns the price of the item (or its variant) set by the price
+// rules active at the moment: the most recently started one overrides the
+// catalog price, up or down. The returned rule is nil for the catalog price.
+func BasePrice(item *Item, variant *ItemVariant, rules []PriceRule, moment time.Time) (float32, *PriceRule) {
+	var applied *PriceRule
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Kind != RuleSetPrice || !rule.ActiveAt(moment) || !rule.AppliesTo(item) {
+			continue
+		}
+		if applied == nil || rule.StartsAt.After(applied.StartsAt) ||
+			rule.StartsAt.Equal(applied.StartsAt) && rule.ID > applied.ID {
+			applied = rule
+		}
+	}
+	if applied == nil {
+		return item.PriceFor(variant), nil
+	}
+	return applied.Apply(item.PriceFor(variant)), applied
+}
+
+// ResolvePrice returns the price of the item (or its variant) after the
+// scheduled rules active at the moment: the discount rules are applied to
+// the base price and the lowest price wins. The returned rule is the one
+// that set the price, nil when the catalog price is used.
+func ResolvePrice(item *Item, variant *ItemVariant, rules []PriceRule, moment time.Time) (float32, *PriceRule) {
+	basePrice, applied := BasePrice(item, variant, rules, moment)
+	price := basePrice
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Kind == RuleSetPrice || !rule.ActiveAt(moment) || !rule.AppliesTo(item) {
+			continue
+		}
+		if rulePrice := rule.Apply(basePrice); rulePrice < price {
+			price, applied = rulePrice, rule
+		}
+	}
+	return price, applied
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"avito/database"
+	"errors"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+var (
+	ErrPromoNotFound      = errors.New("promo code not found")
+	ErrPromoNotActive     = errors.New("promo code is not active")
+	ErrPromoNotApplicable = errors.New("promo code is not applicable to this item")
+	ErrPromoExhausted     = errors.New("promo code redemption limit is reached")
+)
+
+// PromoCode codes are unique among the codes not deleted, so a deleted code
+// can be created again.
+type PromoCode struct {
+	gorm.Model
+	ID             uint       `gorm:"primary_key" autoIncrement:"true"`
+	Code           string     `gorm:"uniqueIndex:idx_promo_code_active,where:deleted_at IS NULL;not null" json:"code" binding:"required"`
+	Kind           string     `gorm:"not null" json:"kind" binding:"required,oneof=percent fixed"`
+	Value          float32    `gorm:"check:value > 0; not null" json:"value" binding:"required,gt=0"`
+	Items          []string   `gorm:"serializer:json;type:jsonb" json:"items"`
+	Categories     []string   `gorm:"serializer:json;type:jsonb" json:"categories"`
+	ValidFrom      *time.Time `json:"valid_from"`
+	ValidUntil     *time.Time `json:"valid_until"`
+	MaxRedemptions *int       `gorm:"check:max_redemptions > 0" json:"max_redemptions"`
+	PerUserLimit   *int       `gorm:"check:per_user_limit > 0" json:"per_user_limit"`
+	Redemptions    int        `gorm:"default:0; not null" json:"redemptions"`
+}
+
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func GetPromoCode(code string) (PromoCode, error) {
+	var promo PromoCode
+	res := database.PostgresDB.Where("code = ?", NormalizePromoCode(code)).First(&promo)
+	if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+		return PromoCode{}, ErrPromoNotFound
+	}
+	if res.Error != nil {
+		return PromoCode{}, res.Error
+	}
+	return promo, nil
+}
+
+func (promo *PromoCode) ActiveAt(moment time.Time) bool {
+	if promo.ValidFrom != nil && moment.Before(*promo.ValidFrom) {
+		return false
+	}
+	if promo.ValidUntil != nil && !moment.Before(*promo.ValidUntil) {
+		return false
+	}
+	return true
+}
+
+func (promo *PromoCode) AppliesTo(item *Item) bool {
+	if len(promo.Items) == 0 && len(promo.Categories) == 0 {
+		return true
+	}
+	for _, name := range promo.Items {
+		if name == item.ItemName {
+			return true
+		}
+	}
+	for _, category := range promo.Categories {
+		if category == item.Category {
+			return true
+		}
+	}
+	return false
+}
+
+// Discount returns the amount taken off listPrice, never more than listPrice.
+func (promo *PromoCode) Discount(listPrice float32) float32 {
+	var discount float32
+	switch promo.Kind {
+	case DiscountPercent:
+		discount = listPrice * promo.Value / 100
+	case DiscountFixed:
+		discount = promo.Value
+	}
+	if discount > listPrice {
+		return listPrice
+	}
+	return discount
+}
+
+// Check validates that the promo code can be used for item right now.
+func (promo *PromoCode) Check(item *Item, moment time.Time) error {
+	if !promo.ActiveAt(moment) {
+		return ErrPromoNotActive
+	}
+	if !promo.AppliesTo(item) {
+		return ErrPromoNotApplicable
+	}
+	return nil
+}
+
+// Redeem counts one more use of the promo code inside tx, respecting the
+// global and per-user limits. The caller must hold the user's row lock.
+func (promo *PromoCode) Redeem(tx *gorm.DB, userID uint) error {
+	if promo.PerUserLimit != nil {
+		var used int64
+		if err := tx.Model(&Purchase{}).Where("user_id = ? AND promo_code_id = ? AND returned_at IS NULL", userID, promo.ID).
+			Count(&used).Error; err != nil {
+			return err
+		}
+		if used >= int64(*promo.PerUserLimit) {
+			return ErrPromoExhausted
+		}
+	}
+	result := tx.Model(&PromoCode{}).
+		Where("id = ? AND (max_redemptions IS NULL OR redemptions < max_redemptions)", promo.ID).
+		UpdateColumn("redemptions", gorm.Expr("redemptions + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPromoExhausted
+	}
+	return nil
+}
@@ -1,13 +1,27 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"time"
+)
 
 type Purchase struct {
 	gorm.Model
-	ID     uint    `gorm:"primary_key" autoIncrement:"true"`
-	ItemID uint    `json:"item_id" binding:"required"`
-	Item   Item    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:ItemID"`
-	UserID uint    `json:"user_id" binding:"required"`
-	User   User    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:UserID"`
-	Price  float32 `gorm:"check:price >= 0; not null" json:"price" binding:"required"`
+	ID          uint         `gorm:"primary_key" autoIncrement:"true"`
+	ItemID      uint         `json:"item_id" binding:"required"`
+	Item        Item         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:ItemID"`
+	UserID      uint         `json:"user_id" binding:"required"`
+	User        User         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:UserID"`
+	Price       float32      `gorm:"check:price >= 0; not null" json:"price" binding:"required"`
+	VariantID   *uint        `json:"variant_id"`
+	Variant     *ItemVariant `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:VariantID"`
+	ListPrice   float32      `gorm:"check:list_price >= 0; not null; default:0" json:"list_price"`
+	Discount    float32      `gorm:"check:discount >= 0; not null; default:0" json:"discount"`
+	PromoCodeID *uint        `json:"promo_code_id"`
+	PromoCode   *PromoCode   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:PromoCodeID"`
+	PriceRuleID *uint        `json:"price_rule_id"`
+	PriceRule   *PriceRule   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:PriceRuleID"`
+	ReturnedAt  *time.Time   `json:"returned_at"`
+	PrevHash    string       `gorm:"size:64" json:"-"`
+	Hash        string       `gorm:"size:64" json:"-"`
 }
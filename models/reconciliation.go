@@ -0,0 +1,123 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"math"
+	"time"
+)
+
+// BalanceDiscrepancy is a user whose balance does not match the ledger. The
+// suspicious rows are the user's transactions and purchases that were
+// deleted or changed after they had been written.
+type BalanceDiscrepancy struct {
+	UserID                 uint    `json:"user_id"`
+	Username               string  `json:"username"`
+	Expected               float32 `json:"expected"`
+	Actual                 float32 `json:"actual"`
+	Difference             float32 `json:"difference"`
+	SuspiciousTransactions []uint  `json:"suspicious_transactions"`
+	SuspiciousPurchases    []uint  `json:"suspicious_purchases"`
+}
+
+type ReconciliationRun struct {
+	gorm.Model
+	ID            uint                 `gorm:"primary_key" autoIncrement:"true" json:"id"`
+	FinishedAt    time.Time            `json:"finished_at"`
+	Users         int                  `json:"users"`
+	Circulating   float32              `json:"circulating"`
+	Imbalance     float32              `json:"imbalance"`
+	Discrepancies []BalanceDiscrepancy `gorm:"serializer:json;type:jsonb" json:"discrepancies"`
+	FrozenUsers   []string             `gorm:"serializer:json;type:jsonb" json:"frozen_users"`
+}
+
+type ledgerBalance struct {
+	UserID   uint
+	Username string
+	Expected float32
+	Actual   float32
+}
+
+// ledgerSQL derives every user's balance from the ledger: the initial coins
+// of users registered before welcome grants, received minus sent transfers
+// and purchases that were not returned.
+const ledgerSQL = `
+SELECT users.id AS user_id, users.username, users.balance - users.debt AS actual,
+	CASE WHEN EXISTS (SELECT 1 FROM transactions w WHERE w.receiver_id = users.id AND w.category = @welcome
+		AND w.deleted_at IS NULL) THEN 0 ELSE @initial END
+	+ coalesce((SELECT sum(amount) FROM transactions r WHERE r.receiver_id = users.id AND r.deleted_at IS NULL), 0)
+	- coalesce((SELECT sum(amount) FROM transactions s WHERE s.sender_id = users.id AND s.deleted_at IS NULL), 0)
+	- coalesce((SELECT sum(price) FROM purchases p WHERE p.user_id = users.id AND p.returned_at IS NULL
+		AND p.deleted_at IS NULL), 0) AS expected
+FROM users
+WHERE users.role <> @system AND users.deleted_at IS NULL
+ORDER BY users.id`
+
+func (run *ReconciliationRun) Consistent() bool {
+	return len(run.Discrepancies) == 0 && math.Abs(float64(run.Imbalance)) <= 0.005
+}
+
+// Reconcile compares every user's balance with the ledger and the sum of all
+// balances with zero, optionally freezing users that do not match, and
+// stores the run.
+func Reconcile(db *gorm.DB, freeze bool, now time.Time) (ReconciliationRun, error) {
+	var balances []ledgerBalance
+	run := ReconciliationRun{Discrepancies: []BalanceDiscrepancy{}, FrozenUsers: []string{}}
+
+	err := db.Raw(ledgerSQL, map[string]interface{}{
+		"welcome": CategoryWelcome,
+		"initial": InitialBalance,
+		"system":  RoleSystem,
+	}).Scan(&balances).Error
+	if err != nil {
+		return run, err
+	}
+	run.Users = len(balances)
+	for _, balance := range balances {
+		difference := balance.Actual - balance.Expected
+		if math.Abs(float64(difference)) <= 0.005 {
+			continue
+		}
+		discrepancy := BalanceDiscrepancy{
+			UserID:     balance.UserID,
+			Username:   balance.Username,
+			Expected:   balance.Expected,
+			Actual:     balance.Actual,
+			Difference: difference,
+		}
+		if err := db.Unscoped().Model(&Transaction{}).
+			Where("(sender_id = ? OR receiver_id = ?) AND (deleted_at IS NOT NULL OR updated_at <> created_at)",
+				balance.UserID, balance.UserID).
+			Order("id").Pluck("id", &discrepancy.SuspiciousTransactions).Error; err != nil {
+			return run, err
+		}
+		if err := db.Unscoped().Model(&Purchase{}).
+			Where("user_id = ? AND (deleted_at IS NOT NULL OR (updated_at <> created_at AND returned_at IS NULL))",
+				balance.UserID).
+			Order("id").Pluck("id", &discrepancy.SuspiciousPurchases).Error; err != nil {
+			return run, err
+		}
+		run.Discrepancies = append(run.Discrepancies, discrepancy)
+	}
+
+	if run.Circulating, run.Imbalance, err = Supply(db); err != nil {
+		return run, err
+	}
+	if freeze && len(run.Discrepancies) > 0 {
+		ids := make([]uint, 0, len(run.Discrepancies))
+		for _, discrepancy := range run.Discrepancies {
+			ids = append(ids, discrepancy.UserID)
+			run.FrozenUsers = append(run.FrozenUsers, discrepancy.Username)
+		}
+		if err := db.Model(&User{}).Where("id IN ?", ids).Update("frozen", true).Error; err != nil {
+			return run, err
+		}
+	}
+	run.FinishedAt = now
+	return run, db.Create(&run).Error
+}
+
+func GetLastReconciliation(db *gorm.DB) (ReconciliationRun, error) {
+	var run ReconciliationRun
+	err := db.Order("id desc").First(&run).Error
+	return run, err
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+const (
+	RefundPending  = "pending"
+	RefundApproved = "approved"
+	RefundRejected = "rejected"
+)
+
+var (
+	ErrRefundWindowClosed  = errors.New("refund window is closed")
+	ErrRefundExists        = errors.New("refund for this purchase is already requested")
+	ErrPurchaseReturned    = errors.New("purchase is already returned")
+	ErrRefundAlreadyClosed = errors.New("refund is already reviewed")
+)
+
+type Refund struct {
+	gorm.Model
+	ID         uint       `gorm:"primary_key" autoIncrement:"true"`
+	PurchaseID uint       `gorm:"index:idx_refund_purchase;not null" json:"purchase_id"`
+	Purchase   Purchase   `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:PurchaseID" json:"-"`
+	UserID     uint       `gorm:"index:idx_refund_user;not null" json:"user_id"`
+	User       User       `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:UserID" json:"-"`
+	Status     string     `gorm:"index:idx_refund_status;not null;default:pending" json:"status"`
+	Reason     string     `json:"reason"`
+	Amount     float32    `gorm:"check:amount >= 0; not null" json:"amount"`
+	ReviewerID *uint      `json:"reviewer_id"`
+	Reviewer   *User      `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:ReviewerID" json:"-"`
+	ReviewedAt *time.Time `json:"reviewed_at"`
+	Comment    string     `json:"comment"`
+}
+
+// RequestRefund opens a pending refund for a purchase of the user made no
+// earlier than window ago.
+func RequestRefund(tx *gorm.DB, purchase *Purchase, reason string, window time.Duration) (Refund, error) {
+	if purchase.ReturnedAt != nil {
+		return Refund{}, ErrPurchaseReturned
+	}
+	if time.Since(purchase.CreatedAt) > window {
+		return Refund{}, ErrRefundWindowClosed
+	}
+	if err := LockUser(tx, purchase.UserID); err != nil {
+		return Refund{}, err
+	}
+	var open int64
+	if err := tx.Model(&Refund{}).Where("purchase_id = ? AND status IN ?", purchase.ID,
+		[]string{RefundPending, RefundApproved}).Count(&open).Error; err != nil {
+		return Refund{}, err
+	}
+	if open > 0 {
+		return Refund{}, ErrRefundExists
+	}
+	refund := Refund{PurchaseID: purchase.ID, UserID: purchase.UserID, Status: RefundPending,
+		Reason: reason, Amount: purchase.Price}
+	if err := tx.Create(&refund).Error; err != nil {
+		return Refund{}, err
+	}
+	return refund, nil
+}
+
+// LockRefund loads a refund for review holding its row lock until tx ends.
+func LockRefund(tx *gorm.DB, id interface{}) (Refund, error) {
+	var refund Refund
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&refund).Error
+	return refund, err
+}
+
+func (refund *Refund) review(tx *gorm.DB, status string, reviewerID uint, comment string) error {
+	if refund.Status != RefundPending {
+		return ErrRefundAlreadyClosed
+	}
+	now := time.Now()
+	refund.Status = status
+	refund.ReviewerID = &reviewerID
+	refund.ReviewedAt = &now
+	refund.Comment = comment
+	return tx.Model(refund).Select("status", "reviewer_id", "reviewed_at", "comment").Updates(refund).Error
+}
+
+func (refund *Refund) Reject(tx *gorm.DB, reviewerID uint, comment string) error {
+	return refund.review(tx, RefundRejected, reviewerID, comment)
+}
+
+// Approve credits the coins back, restores stock and promo redemptions and
+// marks the purchase returned. It must run in the same tx as LockRefund.
+func (refund *Refund) Approve(tx *gorm.DB, reviewerID uint, comment string) error {
+	if err := refund.review(tx, RefundApproved, reviewerID, comment); err != nil {
+		return err
+	}
+
+	var purchase Purchase
+	if err := tx.Where("id = ?", refund.PurchaseID).First(&purchase).Error; err != nil {
+		return err
+	}
+	if purchase.ReturnedAt != nil {
+		return ErrPurchaseReturned
+	}
+	now := time.Now()
+	if err := tx.Model(&purchase).Update("returned_at", now).Error; err != nil {
+		return err
+	}
+
+	if err := Credit(tx, refund.UserID, refund.Amount); err != nil {
+		return err
+	}
+	if err := AdjustSystemBalance(tx, ShopAccount, -refund.Amount); err != nil {
+		return err
+	}
+	if err := Publish(tx, refundReceived(refund)); err != nil {
+		return err
+	}
+
+	if err := tx.Model(&Item{}).Where("id = ? AND stock IS NOT NULL", purchase.ItemID).
+		UpdateColumn("stock", gorm.Expr("stock + 1")).Error; err != nil {
+		return err
+	}
+	if purchase.VariantID != nil {
+		if err := tx.Model(&ItemVariant{}).Where("id = ? AND stock IS NOT NULL", *purchase.VariantID).
+			UpdateColumn("stock", gorm.Expr("stock + 1")).Error; err != nil {
+			return err
+		}
+	}
+	if purchase.PromoCodeID != nil {
+		if err := tx.Model(&PromoCode{}).Where("id = ? AND redemptions > 0", *purchase.PromoCodeID).
+			UpdateColumn("redemptions", gorm.Expr("redemptions - 1")).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
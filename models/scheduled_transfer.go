@@ -0,0 +1,176 @@
+package models
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+const (
+	ScheduleOnce    = "once"
+	ScheduleDaily   = "daily"
+	ScheduleWeekly  = "weekly"
+	ScheduleMonthly = "monthly"
+
+	ScheduledTransferActive    = "active"
+	ScheduledTransferCompleted = "completed"
+	ScheduledTransferCanceled  = "canceled"
+
+	ScheduledRunDone    = "done"
+	ScheduledRunSkipped = "skipped"
+)
+
+var ErrScheduledTransferClosed = errors.New("scheduled transfer is not active")
+
+type ScheduledTransfer struct {
+	gorm.Model
+	ID         uint       `gorm:"primary_key" autoIncrement:"true"`
+	SenderID   uint       `gorm:"index:idx_scheduled_transfer_sender;not null" json:"sender_id"`
+	Sender     User       `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:SenderID" json:"-"`
+	ReceiverID uint       `gorm:"not null" json:"receiver_id"`
+	Receiver   User       `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:ReceiverID" json:"-"`
+	Amount     float32    `gorm:"check:amount > 0; not null" json:"amount"`
+	Memo       string     `gorm:"size:200" json:"memo"`
+	Category   string     `json:"category"`
+	Interval   string     `gorm:"not null;default:once" json:"interval"`
+	Status     string     `gorm:"index:idx_scheduled_transfer_due,priority:1;not null;default:active" json:"status"`
+	NextRunAt  time.Time  `gorm:"index:idx_scheduled_transfer_due,priority:2;not null" json:"next_run_at"`
+	LastRunAt  *time.Time `json:"last_run_at"`
+	LastError  string     `json:"last_error"`
+	Failures   int        `gorm:"not null;default:0" json:"failures"`
+	RetryAt    *time.Time `json:"retry_at"`
+}
+
+// ScheduleRetry delays a schedule whose run failed with an unexpected error,
+// so a broken schedule does not hold back the ones due after it.
+var ScheduleRetry = Backoff{Initial: time.Minute, Max: time.Hour}
+
+// ScheduledTransferRun records one execution slot of a schedule. The unique
+// slot makes a repeated execution of the same slot a no-op.
+type ScheduledTransferRun struct {
+	gorm.Model
+	ID                  uint               `gorm:"primary_key" autoIncrement:"true"`
+	ScheduledTransferID uint               `gorm:"uniqueIndex:idx_scheduled_run_slot;not null" json:"scheduled_transfer_id"`
+	ScheduledTransfer   *ScheduledTransfer `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE; foreignKey:ScheduledTransferID" json:"-"`
+	Slot                time.Time          `gorm:"uniqueIndex:idx_scheduled_run_slot;not null" json:"slot"`
+	Status              string             `gorm:"not null" json:"status"`
+	Reason              string             `json:"reason"`
+	TransactionID       *uint              `json:"transaction_id"`
+	Transaction         *Transaction       `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:TransactionID" json:"-"`
+}
+
+// NextSlot returns the first slot of the schedule after now, skipping the
+// slots missed while the service was down.
+func NextSlot(interval string, slot time.Time, now time.Time) (time.Time, bool) {
+	for {
+		switch interval {
+		case ScheduleDaily:
+			slot = slot.AddDate(0, 0, 1)
+		case ScheduleWeekly:
+			slot = slot.AddDate(0, 0, 7)
+		case ScheduleMonthly:
+			slot = slot.AddDate(0, 1, 0)
+		default:
+			return time.Time{}, false
+		}
+		if slot.After(now) {
+			return slot, true
+		}
+	}
+}
+
+func (schedule *ScheduledTransfer) Cancel(tx *gorm.DB) error {
+	if schedule.Status != ScheduledTransferActive {
+		return ErrScheduledTransferClosed
+	}
+	schedule.Status = ScheduledTransferCanceled
+	return tx.Model(schedule).Select("status").Updates(schedule).Error
+}
+
+// RunDueTransfer executes the oldest due schedule in its own tx and reports
+// whether there was one. Rows locked by another worker are skipped. A run
+// that fails unexpectedly is rolled back and retried later, see fail.
+func RunDueTransfer(db *gorm.DB, now time.Time) (bool, error) {
+	var schedule ScheduledTransfer
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_run_at <= ? AND (retry_at IS NULL OR retry_at <= ?)",
+				ScheduledTransferActive, now, now).
+			Order("next_run_at").Limit(1).Find(&schedule)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		return schedule.run(tx, now)
+	})
+	if err != nil && schedule.ID != 0 {
+		return true, schedule.fail(db, err, now)
+	}
+	return schedule.ID != 0, err
+}
+
+// fail records the error of a run and postpones the next attempt of the same
+// slot with a growing delay.
+func (schedule *ScheduledTransfer) fail(db *gorm.DB, cause error, now time.Time) error {
+	failures := schedule.Failures + 1
+	return db.Model(&ScheduledTransfer{}).Where("id = ?", schedule.ID).Updates(map[string]interface{}{
+		"failures":   failures,
+		"retry_at":   now.Add(ScheduleRetry.Delay(failures)),
+		"last_error": cause.Error(),
+	}).Error
+}
+
+func (schedule *ScheduledTransfer) run(tx *gorm.DB, now time.Time) error {
+	run := ScheduledTransferRun{ScheduledTransferID: schedule.ID, Slot: schedule.NextRunAt, Status: ScheduledRunDone}
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&run)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		if err := schedule.execute(tx, &run); err != nil {
+			return err
+		}
+		schedule.LastRunAt = &now
+		schedule.LastError = run.Reason
+	}
+	schedule.Failures, schedule.RetryAt = 0, nil
+
+	if next, ok := NextSlot(schedule.Interval, schedule.NextRunAt, now); ok {
+		schedule.NextRunAt = next
+	} else {
+		schedule.Status = ScheduledTransferCompleted
+	}
+	return tx.Model(schedule).Select("status", "next_run_at", "last_run_at", "last_error", "failures", "retry_at").Updates(schedule).Error
+}
+
+func (schedule *ScheduledTransfer) execute(tx *gorm.DB, run *ScheduledTransferRun) error {
+	users, err := LockUsers(tx, schedule.SenderID, schedule.ReceiverID)
+	if err != nil {
+		return err
+	}
+	var sender User
+	receiverFound := false
+	for _, user := range users {
+		if user.ID == schedule.SenderID {
+			sender = user
+		}
+		receiverFound = receiverFound || user.ID == schedule.ReceiverID
+	}
+
+	var transaction Transaction
+	switch {
+	case sender.ID == 0 || !receiverFound:
+		err = gorm.ErrRecordNotFound
+	default:
+		transaction, err = Transfer(tx, &sender, schedule.ReceiverID, schedule.Amount, schedule.Memo, schedule.Category)
+	}
+	switch {
+	case errors.Is(err, ErrInsufficientFunds), errors.Is(err, ErrAccountFrozen), errors.Is(err, gorm.ErrRecordNotFound):
+		run.Status, run.Reason = ScheduledRunSkipped, err.Error()
+	case err != nil:
+		return err
+	default:
+		run.TransactionID = &transaction.ID
+	}
+	return tx.Model(run).Select("status", "reason", "transaction_id").Updates(run).Error
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"database/sql"
+	"gorm.io/gorm"
+	"strconv"
+	"time"
+)
+
+const (
+	EntryInitial  = "initial"
+	EntryReceived = "received"
+	EntrySent     = "sent"
+	EntryPurchase = "purchase"
+	EntryReturn   = "return"
+)
+
+type StatementEntry struct {
+	At           time.Time `json:"at"`
+	Kind         string    `json:"kind"`
+	Counterparty string    `json:"counterparty"`
+	Amount       float32   `json:"amount"`
+	Balance      float32   `json:"balance"`
+	Memo         string    `json:"memo"`
+	Category     string    `json:"category"`
+	Reference    uint      `json:"reference"`
+}
+
+// statementEntriesSQL lists every change of the user's coins with the same
+// rules as the reconciliation ledger: a returned purchase is shown as the
+// purchase and its return.
+const statementEntriesSQL = `
+SELECT u.created_at AS at, @initialKind AS kind, '' AS counterparty, CAST(@initial AS real) AS amount,
+	'' AS memo, '' AS category, u.id AS reference
+FROM users u
+WHERE u.id = @user AND NOT EXISTS (SELECT 1 FROM transactions w WHERE w.receiver_id = u.id
+	AND w.category = @welcome AND w.deleted_at IS NULL)
+UNION ALL
+SELECT t.created_at, @receivedKind, coalesce(s.username, ''), t.amount, coalesce(t.memo, ''),
+	coalesce(t.category, ''), t.id
+FROM transactions t LEFT JOIN users s ON s.id = t.sender_id
+WHERE t.receiver_id = @user AND t.deleted_at IS NULL
+UNION ALL
+SELECT t.created_at, @sentKind, coalesce(r.username, ''), -t.amount, coalesce(t.memo, ''),
+	coalesce(t.category, ''), t.id
+FROM transactions t LEFT JOIN users r ON r.id = t.receiver_id
+WHERE t.sender_id = @user AND t.deleted_at IS NULL
+UNION ALL
+SELECT p.created_at, @purchaseKind, coalesce(i.item_name, ''), -p.price, '', '', p.id
+FROM purchases p LEFT JOIN items i ON i.id = p.item_id
+WHERE p.user_id = @user AND p.deleted_at IS NULL
+UNION ALL
+SELECT p.returned_at, @returnKind, coalesce(i.item_name, ''), p.price, '', '', p.id
+FROM purchases p LEFT JOIN items i ON i.id = p.item_id
+WHERE p.user_id = @user AND p.returned_at IS NOT NULL AND p.deleted_at IS NULL`
+
+// StatementTimeout limits how long a statement keeps its tx and connection
+// while a slow client reads it.
+var StatementTimeout = 5 * time.Minute
+
+// Statement streams the entries of a period one by one with the running
+// balance, so a long history is never loaded at once. It reads a single
+// snapshot, so the entries always add up to the opening balance.
+type Statement struct {
+	Opening float32
+	Closing float32
+	tx      *gorm.DB
+	rows    *sql.Rows
+}
+
+func statementArgs(userID uint, from time.Time, to time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"user":         userID,
+		"from":         from,
+		"to":           to,
+		"initial":      InitialBalance,
+		"welcome":      CategoryWelcome,
+		"initialKind":  EntryInitial,
+		"receivedKind": EntryReceived,
+		"sentKind":     EntrySent,
+		"purchaseKind": EntryPurchase,
+		"returnKind":   EntryReturn,
+	}
+}
+
+// OpenStatement computes the opening balance at from and starts reading the
+// entries in [from, to) in one read-only repeatable read tx, which stays open
+// until the statement is closed. Postgres cancels the reading after
+// StatementTimeout.
+func OpenStatement(db *gorm.DB, userID uint, from time.Time, to time.Time) (*Statement, error) {
+	tx := db.Begin(&sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	statement := Statement{tx: tx}
+	args := statementArgs(userID, from, to)
+
+	err := tx.Exec("SELECT set_config('statement_timeout', ?, true)",
+		strconv.FormatInt(StatementTimeout.Milliseconds(), 10)).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	err = tx.Raw("SELECT coalesce(sum(amount), 0) FROM ("+statementEntriesSQL+") entries WHERE at < @from", args).
+		Scan(&statement.Opening).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	statement.Closing = statement.Opening
+	statement.rows, err = tx.Raw("SELECT * FROM ("+statementEntriesSQL+") entries "+
+		"WHERE at >= @from AND at < @to ORDER BY at, reference", args).Rows()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return &statement, nil
+}
+
+// Next reads the following entry into entry and returns false after the
+// last one.
+func (statement *Statement) Next(entry *StatementEntry) (bool, error) {
+	if !statement.rows.Next() {
+		return false, statement.rows.Err()
+	}
+	*entry = StatementEntry{}
+	if err := statement.tx.ScanRows(statement.rows, entry); err != nil {
+		return false, err
+	}
+	statement.Closing += entry.Amount
+	entry.Balance = statement.Closing
+	return true, nil
+}
+
+// Close stops reading and ends the tx of the statement.
+func (statement *Statement) Close() error {
+	if err := statement.rows.Close(); err != nil {
+		statement.tx.Rollback()
+		return err
+	}
+	return statement.tx.Commit().Error
+}
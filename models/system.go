@@ -0,0 +1,189 @@
+package models
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	RoleSystem = "system"
+
+	TreasuryAccount = "system:treasury"
+	ShopAccount     = "system:shop"
+	MintAccount     = "system:mint"
+	BurnAccount     = "system:burn"
+)
+
+var SystemAccounts = []string{TreasuryAccount, ShopAccount, MintAccount, BurnAccount}
+
+var ErrNotSystemAccount = errors.New("not a system account")
+
+// EnsureSystemAccounts creates the non-login accounts coins are issued from.
+// Their passwords are not bcrypt hashes, so nobody can log in as them.
+func EnsureSystemAccounts(db *gorm.DB) error {
+	for _, name := range SystemAccounts {
+		account := User{Username: name, Password: "!" + name, Role: RoleSystem}
+		err := db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "username"}}, DoNothing: true}).
+			Select("CreatedAt", "UpdatedAt", "Username", "Password", "Balance", "Role", "Debt").
+			Create(&account).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetSystemAccount(tx *gorm.DB, name string) (User, error) {
+	var account User
+	err := tx.Where("username = ? AND role = ?", name, RoleSystem).First(&account).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return account, ErrNotSystemAccount
+	}
+	return account, err
+}
+
+func (user *User) IsSystem() bool {
+	return user.Role == RoleSystem
+}
+
+// AdjustSystemBalance adds delta to a system account. System accounts may go
+// negative: the mint is negative by the amount of coins in circulation.
+func AdjustSystemBalance(tx *gorm.DB, name string, delta float32) error {
+	result := tx.Model(&User{}).Where("username = ? AND role = ?", name, RoleSystem).
+		Update("balance", gorm.Expr("balance + ?", delta))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotSystemAccount
+	}
+	return nil
+}
+
+// issue records coins the receiver already got as taken from the mint.
+func issue(tx *gorm.DB, receiverID uint, amount float32, memo string, category string) (Transaction, error) {
+	mint, err := GetSystemAccount(tx, MintAccount)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if err := AdjustSystemBalance(tx, MintAccount, -amount); err != nil {
+		return Transaction{}, err
+	}
+	transaction := Transaction{SenderID: mint.ID, ReceiverID: receiverID, Amount: amount, Memo: memo, Category: category}
+	if err := tx.Create(&transaction).Error; err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+// Mint issues new coins to the receiver. The mint account goes negative by
+// the same amount, so the sum of all balances stays zero.
+func Mint(tx *gorm.DB, receiverID uint, amount float32, memo string, category string) (Transaction, error) {
+	if err := Credit(tx, receiverID, amount); err != nil {
+		return Transaction{}, err
+	}
+	transaction, err := issue(tx, receiverID, amount, memo, category)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if err := Publish(tx, coinReceived(transaction, MintAccount)); err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+// Burn takes coins out of circulation moving them from the user to the burn
+// account.
+func Burn(tx *gorm.DB, userID uint, amount float32, memo string) (Transaction, error) {
+	burn, err := GetSystemAccount(tx, BurnAccount)
+	if err != nil {
+		return Transaction{}, err
+	}
+	result := tx.Model(&User{}).Where("id = ? AND balance >= ?", userID, amount).
+		Update("balance", gorm.Expr("balance - ?", amount))
+	if result.Error != nil {
+		return Transaction{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return Transaction{}, ErrInsufficientFunds
+	}
+	if err := AdjustSystemBalance(tx, BurnAccount, amount); err != nil {
+		return Transaction{}, err
+	}
+	transaction := Transaction{SenderID: userID, ReceiverID: burn.ID, Amount: amount, Memo: memo, Category: CategoryBurn}
+	if err := tx.Create(&transaction).Error; err != nil {
+		return Transaction{}, err
+	}
+	if err := Publish(tx, coinSent(transaction)); err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+// MoveSystemCoins transfers coins between two system accounts, e.g. the shop
+// revenue to the treasury.
+func MoveSystemCoins(tx *gorm.DB, from string, to string, amount float32, memo string) (Transaction, error) {
+	sender, err := GetSystemAccount(tx, from)
+	if err != nil {
+		return Transaction{}, err
+	}
+	receiver, err := GetSystemAccount(tx, to)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if err := AdjustSystemBalance(tx, from, -amount); err != nil {
+		return Transaction{}, err
+	}
+	if err := AdjustSystemBalance(tx, to, amount); err != nil {
+		return Transaction{}, err
+	}
+	transaction := Transaction{SenderID: sender.ID, ReceiverID: receiver.ID, Amount: amount, Memo: memo, Category: CategoryOther}
+	if err := tx.Create(&transaction).Error; err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+// Supply returns the coins held by users and the sum of all balances net of
+// debt, which is zero while every coin is accounted for.
+func Supply(db *gorm.DB) (circulating float32, imbalance float32, err error) {
+	var totals struct {
+		Circulating float32
+		Imbalance   float32
+	}
+	err = db.Model(&User{}).
+		Select("coalesce(sum(balance - debt) filter (where role <> ?), 0) as circulating, "+
+			"coalesce(sum(balance - debt), 0) as imbalance", RoleSystem).
+		Scan(&totals).Error
+	return totals.Circulating, totals.Imbalance, err
+}
+
+// OpenSupply books the coins that existed before the system accounts against
+// the mint, so the sum of all balances starts at zero. It does nothing once
+// the opening or any welcome grant is booked, so later drift stays visible.
+func OpenSupply(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		mint, err := GetSystemAccount(tx.Clauses(clause.Locking{Strength: "UPDATE"}), MintAccount)
+		if err != nil {
+			return err
+		}
+		var opened int64
+		if err := tx.Model(&Transaction{}).Where("category IN ?", []string{CategoryOpening, CategoryWelcome}).
+			Count(&opened).Error; err != nil {
+			return err
+		}
+		if opened > 0 {
+			return nil
+		}
+		_, imbalance, err := Supply(tx)
+		if err != nil || imbalance <= 0 {
+			return err
+		}
+		if err := AdjustSystemBalance(tx, MintAccount, -imbalance); err != nil {
+			return err
+		}
+		return tx.Create(&Transaction{SenderID: mint.ID, ReceiverID: mint.ID, Amount: imbalance,
+			Memo: "coins issued before system accounts", Category: CategoryOpening}).Error
+	})
+}
@@ -1,13 +1,204 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"unicode"
+)
+
+const (
+	CategoryThanks  = "thanks"
+	CategoryBet     = "bet"
+	CategoryPayback = "payback"
+	CategoryGift    = "gift"
+	CategoryOther   = "other"
+
+	CategoryAllowance = "allowance"
+	CategoryGrant     = "grant"
+	CategoryWelcome   = "welcome"
+	CategoryBurn      = "burn"
+	CategoryOpening   = "opening"
+
+	MemoMaxLength = 200
+)
+
+var TransferCategories = []string{CategoryThanks, CategoryBet, CategoryPayback, CategoryGift, CategoryOther,
+	CategoryAllowance, CategoryGrant, CategoryWelcome, CategoryBurn}
+
+var (
+	ErrInsufficientFunds    = errors.New("insufficient funds to complete the transaction")
+	ErrAccountFrozen        = errors.New("account is frozen")
+	ErrAlreadyReversed      = errors.New("transaction is already reversed")
+	ErrCannotReverseReverse = errors.New("reversal can not be reversed")
+	ErrReversalUnderfunded  = errors.New("receiver does not have enough coins for reversal")
+)
 
 type Transaction struct {
 	gorm.Model
-	ID         uint    `gorm:"primary_key" autoIncrement:"true"`
-	SenderID   uint    `json:"sender_id" binding:"required"`
-	Sender     User    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:SenderID"`
-	ReceiverID uint    `json:"receiver_id" binding:"required"`
-	Receiver   User    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:ReceiverID"`
-	Amount     float32 `gorm:"check:amount > 0;" json:"amount" binding:"required"`
+	ID           uint    `gorm:"primary_key" autoIncrement:"true"`
+	SenderID     uint    `json:"sender_id" binding:"required"`
+	Sender       User    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:SenderID"`
+	ReceiverID   uint    `json:"receiver_id" binding:"required"`
+	Receiver     User    `gorm:"constraint:OnUpdate:CASCADE,OnDelete:SET NULL; foreignKey:ReceiverID"`
+	Amount       float32 `gorm:"check:amount > 0;" json:"amount" binding:"required"`
+	ReversalOfID *uint   `gorm:"uniqueIndex:idx_transaction_reversal_of" json:"reversal_of_id"`
+	ReversedByID *uint   `json:"reversed_by_id"`
+	Reason       string  `json:"reason"`
+	Memo         string  `gorm:"size:200" json:"memo"`
+	Category     string  `gorm:"index" json:"category"`
+	PrevHash     string  `gorm:"size:64" json:"-"`
+	Hash         string  `gorm:"size:64" json:"-"`
+}
+
+func IsTransferCategory(category string) bool {
+	for _, known := range TransferCategories {
+		if category == known {
+			return true
+		}
+	}
+	return false
+}
+
+// SanitizeMemo drops control characters, collapses whitespace and cuts the
+// memo to MemoMaxLength runes.
+func SanitizeMemo(memo string) string {
+	memo = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, memo)
+	memo = strings.Join(strings.Fields(memo), " ")
+	if runes := []rune(memo); len(runes) > MemoMaxLength {
+		memo = strings.TrimSpace(string(runes[:MemoMaxLength]))
+	}
+	return memo
+}
+
+// Transfer moves amount coins from sender to the receiver and records the
+// transaction inside tx. The balance is checked again when it is taken, so a
+// stale sender can not overspend; its balance is decreased in place. Callers
+// lock both users first, the chain is locked only by the final insert.
+func Transfer(tx *gorm.DB, sender *User, receiverID uint, amount float32, memo string, category string) (Transaction, error) {
+	if sender.Frozen {
+		return Transaction{}, ErrAccountFrozen
+	}
+	if sender.Balance < amount {
+		return Transaction{}, ErrInsufficientFunds
+	}
+	if err := Debit(tx, sender.ID, amount); err != nil {
+		return Transaction{}, err
+	}
+	sender.Balance -= amount
+	if err := Credit(tx, receiverID, amount); err != nil {
+		return Transaction{}, err
+	}
+	transaction := Transaction{
+		SenderID:   sender.ID,
+		ReceiverID: receiverID,
+		Amount:     amount,
+		Memo:       SanitizeMemo(memo),
+		Category:   category,
+	}
+	if err := tx.Create(&transaction).Error; err != nil {
+		return Transaction{}, err
+	}
+	if err := Publish(tx, coinSent(transaction)); err != nil {
+		return Transaction{}, err
+	}
+	if err := Publish(tx, coinReceived(transaction, sender.Username)); err != nil {
+		return Transaction{}, err
+	}
+	return transaction, nil
+}
+
+// LockUsers locks several users in id order so that concurrent operations on
+// the same pair can not deadlock.
+func LockUsers(tx *gorm.DB, ids ...uint) ([]User, error) {
+	var users []User
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", ids).Order("id").Find(&users).Error
+	return users, err
+}
+
+// ReverseTransaction moves the coins of the original transfer back with a
+// compensating transaction. With allowNegative the coins the receiver lacks
+// are recorded as debt that is paid off from the next credits.
+func ReverseTransaction(tx *gorm.DB, originalID uint, adminID uint, reason string, allowNegative bool) (Transaction, error) {
+	var original Transaction
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", originalID).
+		First(&original).Error; err != nil {
+		return Transaction{}, err
+	}
+	if original.ReversalOfID != nil {
+		return Transaction{}, ErrCannotReverseReverse
+	}
+	var reversals int64
+	if err := tx.Model(&Transaction{}).Where("reversal_of_id = ?", original.ID).Count(&reversals).Error; err != nil {
+		return Transaction{}, err
+	}
+	if reversals > 0 {
+		return Transaction{}, ErrAlreadyReversed
+	}
+
+	users, err := LockUsers(tx, original.SenderID, original.ReceiverID)
+	if err != nil {
+		return Transaction{}, err
+	}
+	var receiver User
+	for _, user := range users {
+		if user.ID == original.ReceiverID {
+			receiver = user
+		}
+	}
+	if receiver.ID == 0 {
+		return Transaction{}, gorm.ErrRecordNotFound
+	}
+
+	taken, shortfall := original.Amount, float32(0)
+	if receiver.Balance < original.Amount {
+		if !allowNegative {
+			return Transaction{}, ErrReversalUnderfunded
+		}
+		taken, shortfall = receiver.Balance, original.Amount-receiver.Balance
+	}
+	result := tx.Model(&User{}).Where("id = ?", receiver.ID).Updates(map[string]interface{}{
+		"balance": gorm.Expr("balance - ?", taken),
+		"debt":    gorm.Expr("debt + ?", shortfall),
+	})
+	if result.Error != nil {
+		return Transaction{}, result.Error
+	}
+	if err := Credit(tx, original.SenderID, original.Amount); err != nil {
+		return Transaction{}, err
+	}
+
+	reversal := Transaction{
+		SenderID:     original.ReceiverID,
+		ReceiverID:   original.SenderID,
+		Amount:       original.Amount,
+		ReversalOfID: &original.ID,
+		ReversedByID: &adminID,
+		Reason:       reason,
+	}
+	if err := tx.Create(&reversal).Error; err != nil {
+		return Transaction{}, err
+	}
+	for _, user := range users {
+		if user.Role == RoleSystem {
+			continue
+		}
+		event := coinSent(reversal)
+		if user.ID == reversal.ReceiverID {
+			event = coinReceived(reversal, receiver.Username)
+		}
+		if err := Publish(tx, event); err != nil {
+			return Transaction{}, err
+		}
+	}
+	return reversal, nil
 }
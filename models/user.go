@@ -2,8 +2,18 @@ package models
 
 import (
 	"avito/database"
+	"errors"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+
+	InitialBalance = 1000
+	WelcomeMemo    = "welcome coins"
 )
 
 type User struct {
@@ -11,7 +21,10 @@ type User struct {
 	ID       uint    `gorm:"primary_key" autoIncrement:"true"`
 	Username string  `gorm:"index:idx_username;unique;not null;" json:"username" binding:"required"`
 	Password string  `gorm:"unique;not null;" json:"password" binding:"required"`
-	Balance  float32 `gorm:"default:1000; check:balance >= 0" json:"-"`
+	Balance  float32 `gorm:"default:1000; check:chk_users_balance_system,balance >= 0 OR role = 'system'" json:"-"`
+	Role     string  `gorm:"default:user; not null" json:"-"`
+	Debt     float32 `gorm:"default:0; not null; check:debt >= 0" json:"-"`
+	Frozen   bool    `gorm:"default:false; not null" json:"-"`
 }
 
 func GetUserByUsername(username string) (User, error) {
@@ -22,14 +35,68 @@ func GetUserByUsername(username string) (User, error) {
 	return user, nil
 }
 
-func (user *User) CreateUser() error {
-	res := database.PostgresDB.Create(&user)
-	if res.Error != nil {
-		return res.Error
+func GetUserByID(id interface{}) (User, error) {
+	var user User
+	if res := database.PostgresDB.Where("ID = ?", id).First(&user); res.Error != nil {
+		return User{}, res.Error
+	}
+	return user, nil
+}
+
+// LockUser takes a row lock on the user until tx ends, serializing
+// concurrent operations of the same user.
+func LockUser(tx *gorm.DB, userID uint) error {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+		Where("id = ?", userID).Take(&User{}).Error
+}
+
+// Debit takes amount coins from the user if the current balance allows it.
+func Debit(tx *gorm.DB, userID uint, amount float32) error {
+	result := tx.Model(&User{}).Where("id = ? AND balance >= ?", userID, amount).
+		Update("balance", gorm.Expr("balance - ?", amount))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInsufficientFunds
+	}
+	return nil
+}
+
+// Credit adds amount to the user's coins inside tx, paying off the debt left
+// by a reversal first.
+func Credit(tx *gorm.DB, userID uint, amount float32) error {
+	result := tx.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"balance": gorm.Expr("balance + GREATEST(? - debt, 0)", amount),
+		"debt":    gorm.Expr("GREATEST(debt - ?, 0)", amount),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("could not update user's balance")
 	}
 	return nil
 }
 
+func (user *User) IsAdmin() bool {
+	return user.Role == RoleAdmin
+}
+
+// CreateUser registers the user with the welcome coins issued by the mint.
+func (user *User) CreateUser() error {
+	return database.PostgresDB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if user.Balance <= 0 {
+			return nil
+		}
+		_, err := issue(tx, user.ID, user.Balance, WelcomeMemo, CategoryWelcome)
+		return err
+	})
+}
+
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	return string(bytes), err
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"errors"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrVariantRequired = errors.New("item variant must be specified")
+	ErrVariantNotFound = errors.New("item variant not found")
+)
+
+type ItemVariant struct {
+	gorm.Model
+	ID     uint     `gorm:"primary_key" autoIncrement:"true"`
+	ItemID uint     `gorm:"index:idx_variant_item;not null" json:"-"`
+	SKU    string   `gorm:"column:sku;unique;not null" json:"sku"`
+	Size   string   `json:"size"`
+	Color  string   `json:"color"`
+	Price  *float32 `gorm:"check:price >= 0" json:"price"`
+	Stock  *int     `gorm:"check:stock >= 0" json:"stock"`
+	// Default is bought when the request names no variant.
+	Default bool `gorm:"column:is_default;default:false;not null" json:"default"`
+}
+
+func (variant *ItemVariant) InStock() bool {
+	return variant.Stock == nil || *variant.Stock > 0
+}
+
+func (variant *ItemVariant) TakeFromStock(tx *gorm.DB) error {
+	if variant.Stock == nil {
+		return nil
+	}
+	result := tx.Model(&ItemVariant{}).Where("id = ? AND stock > 0", variant.ID).
+		UpdateColumn("stock", gorm.Expr("stock - 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSoldOut
+	}
+	return nil
+}
+
+// SelectVariant picks a variant by SKU or by size and color. Without any of
+// them the default variant is bought, items without variants are bought as
+// is and items with variants but no default one need the variant named.
+func SelectVariant(variants []ItemVariant, sku, size, color string) (*ItemVariant, error) {
+	if sku == "" && size == "" && color == "" {
+		for i := range variants {
+			if variants[i].Default {
+				return &variants[i], nil
+			}
+		}
+		if len(variants) > 0 {
+			return nil, ErrVariantRequired
+		}
+		return nil, nil
+	}
+	if len(variants) == 0 {
+		return nil, ErrVariantNotFound
+	}
+	var found *ItemVariant
+	for i := range variants {
+		variant := &variants[i]
+		if sku != "" && variant.SKU != sku {
+			continue
+		}
+		if size != "" && variant.Size != size || color != "" && variant.Color != color {
+			continue
+		}
+		if found != nil {
+			return nil, ErrVariantRequired
+		}
+		found = variant
+	}
+	if found == nil {
+		return nil, ErrVariantNotFound
+	}
+	return found, nil
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+const webhookErrorMaxLength = 500
+
+type WebhookSubscription struct {
+	gorm.Model
+	ID          uint     `gorm:"primary_key" autoIncrement:"true" json:"id"`
+	URL         string   `gorm:"not null" json:"url"`
+	Secret      string   `gorm:"not null" json:"-"`
+	EventTypes  []string `gorm:"serializer:json;type:jsonb" json:"event_types"`
+	Description string   `json:"description"`
+}
+
+// Wants reports whether the subscription receives events of the type, no
+// types means all of them.
+func (subscription *WebhookSubscription) Wants(eventType string) bool {
+	if len(subscription.EventTypes) == 0 {
+		return true
+	}
+	for _, wanted := range subscription.EventTypes {
+		if wanted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookDelivery struct {
+	ID             uint                `gorm:"primary_key" autoIncrement:"true" json:"id"`
+	CreatedAt      time.Time           `json:"created_at"`
+	UpdatedAt      time.Time           `json:"updated_at"`
+	SubscriptionID uint                `gorm:"uniqueIndex:idx_webhook_delivery_event;not null" json:"subscription_id"`
+	Subscription   WebhookSubscription `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE; foreignKey:SubscriptionID" json:"-"`
+	EventID        uint                `gorm:"uniqueIndex:idx_webhook_delivery_event;not null" json:"event_id"`
+	Event          OutboxEvent         `gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE; foreignKey:EventID" json:"event"`
+	Status         string              `gorm:"index:idx_webhook_delivery_due;not null;default:pending" json:"status"`
+	NextAttemptAt  time.Time           `gorm:"index:idx_webhook_delivery_due" json:"next_attempt_at"`
+	Attempts       int                 `gorm:"not null;default:0" json:"attempts"`
+	LastStatus     int                 `json:"last_status"`
+	LastError      string              `json:"last_error"`
+	DeliveredAt    *time.Time          `json:"delivered_at"`
+}
+
+// WebhookRetry backs off after every failed attempt and gives up after
+// MaxAttempts. Lease is how long a delivery being sent is hidden from other
+// workers, it must outlast the send timeout.
+type WebhookRetry struct {
+	MaxAttempts int
+	Lease       time.Duration
+	Backoff
+}
+
+// RecordAttempt applies the outcome of a delivery attempt: delivered, retried
+// later or moved to the dead letters.
+func (delivery *WebhookDelivery) RecordAttempt(status int, err error, now time.Time, retry WebhookRetry) {
+	delivery.Attempts++
+	delivery.LastStatus = status
+	if err == nil {
+		delivery.Status = DeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.LastError = ""
+		return
+	}
+	delivery.LastError = err.Error()
+	if len(delivery.LastError) > webhookErrorMaxLength {
+		delivery.LastError = delivery.LastError[:webhookErrorMaxLength]
+	}
+	if delivery.Attempts >= retry.MaxAttempts {
+		delivery.Status = DeliveryDead
+		return
+	}
+	delivery.NextAttemptAt = now.Add(retry.Delay(delivery.Attempts))
+}
+
+// EnqueueWebhooks creates deliveries of the outbox events not yet handed to
+// webhooks and returns how many events were taken.
+func EnqueueWebhooks(db *gorm.DB, now time.Time, limit int) (int, error) {
+	var events []OutboxEvent
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("webhooks_at IS NULL").Order("id").Limit(limit).Find(&events).Error
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		var subscriptions []WebhookSubscription
+		if err := tx.Find(&subscriptions).Error; err != nil {
+			return err
+		}
+		var deliveries []WebhookDelivery
+		ids := make([]uint, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.ID)
+			for _, subscription := range subscriptions {
+				if subscription.Wants(event.Type) {
+					deliveries = append(deliveries, WebhookDelivery{SubscriptionID: subscription.ID, EventID: event.ID,
+						Status: DeliveryPending, NextAttemptAt: now})
+				}
+			}
+		}
+		if len(deliveries) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&OutboxEvent{}).Where("id IN ?", ids).Update("webhooks_at", now).Error
+	})
+	return len(events), err
+}
+
+// DeliverDueWebhook claims one delivery that is due by moving its next
+// attempt past the lease, sends it with no tx or lock held and records the
+// attempt. A delivery whose worker died while sending is sent again after the
+// lease. It reports whether a delivery was found.
+func DeliverDueWebhook(db *gorm.DB, now time.Time, retry WebhookRetry,
+	send func(delivery *WebhookDelivery) (int, error)) (bool, error) {
+	var delivery WebhookDelivery
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Preload("Subscription").Preload("Event").
+			Where("status = ? AND next_attempt_at <= ?", DeliveryPending, now).
+			Order("next_attempt_at").Limit(1).Find(&delivery)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		if delivery.Subscription.ID == 0 {
+			delivery.Status, delivery.LastError = DeliveryDead, "subscription is removed"
+			return tx.Model(&delivery).Select("status", "last_error").Updates(&delivery).Error
+		}
+		delivery.NextAttemptAt = now.Add(retry.Lease)
+		return tx.Model(&delivery).Select("next_attempt_at").Updates(&delivery).Error
+	})
+	if err != nil || delivery.ID == 0 || delivery.Status == DeliveryDead {
+		return delivery.ID != 0, err
+	}
+
+	status, err := send(&delivery)
+	delivery.RecordAttempt(status, err, now, retry)
+	return true, db.Model(&delivery).
+		Select("status", "attempts", "next_attempt_at", "last_status", "last_error", "delivered_at").
+		Updates(&delivery).Error
+}
+
+// RetryWebhookDelivery gives a dead delivery a fresh set of attempts.
+func RetryWebhookDelivery(db *gorm.DB, id uint, now time.Time) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	res := db.Model(&delivery).Where("id = ? AND status = ?", id, DeliveryDead).
+		Updates(map[string]interface{}{"status": DeliveryPending, "attempts": 0, "next_attempt_at": now})
+	if res.Error != nil {
+		return delivery, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return delivery, gorm.ErrRecordNotFound
+	}
+	return delivery, db.Preload("Event").First(&delivery, id).Error
+}
@@ -0,0 +1,225 @@
+package relay
+
+import (
+	"avito/config"
+	"avito/database"
+	"avito/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/segmentio/kafka-go"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	relayBatch = 100
+	// relayTimeout bounds publishing a batch, the lease outlasts it so no
+	// other relay starts while a batch may still be in flight.
+	relayTimeout = 30 * time.Second
+	relayLease   = relayTimeout + time.Minute
+)
+
+// Sink receives outbox events in id order. Publish must return an error
+// unless every event is stored, the batch is then relayed again.
+type Sink interface {
+	Publish(ctx context.Context, events []models.OutboxEvent) error
+	Close() error
+}
+
+// LogSink writes every event to the service log.
+type LogSink struct{}
+
+func (LogSink) Publish(_ context.Context, events []models.OutboxEvent) error {
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		log.Printf("[relay] %s", line)
+	}
+	return nil
+}
+
+func (LogSink) Close() error {
+	return nil
+}
+
+// FileSink appends events to a file as JSON lines.
+type FileSink struct {
+	file *os.File
+}
+
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (sink *FileSink) Publish(_ context.Context, events []models.OutboxEvent) error {
+	encoder := json.NewEncoder(sink.file)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return sink.file.Sync()
+}
+
+func (sink *FileSink) Close() error {
+	return sink.file.Close()
+}
+
+// Message is a record for a Kafka-compatible broker. The key is the user id,
+// so the events of one user stay in one partition and keep their order.
+type Message struct {
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+}
+
+type Broker interface {
+	Produce(ctx context.Context, topic string, messages []Message) error
+	Close() error
+}
+
+// BrokerSink publishes events to a topic of a Broker.
+type BrokerSink struct {
+	Broker Broker
+	Topic  string
+}
+
+func (sink BrokerSink) Publish(ctx context.Context, events []models.OutboxEvent) error {
+	messages := make([]Message, 0, len(events))
+	for _, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, Message{
+			Key:   []byte(strconv.FormatUint(uint64(event.UserID), 10)),
+			Value: value,
+			Headers: map[string]string{
+				"event_id":   strconv.FormatUint(uint64(event.ID), 10),
+				"event_type": event.Type,
+			},
+		})
+	}
+	return sink.Broker.Produce(ctx, sink.Topic, messages)
+}
+
+func (sink BrokerSink) Close() error {
+	return sink.Broker.Close()
+}
+
+// KafkaBroker produces to Kafka or any broker speaking its protocol.
+type KafkaBroker struct {
+	writer *kafka.Writer
+}
+
+func NewKafkaBroker(addresses []string) *KafkaBroker {
+	return &KafkaBroker{writer: &kafka.Writer{
+		Addr:         kafka.TCP(addresses...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}}
+}
+
+func (broker *KafkaBroker) Produce(ctx context.Context, topic string, messages []Message) error {
+	records := make([]kafka.Message, 0, len(messages))
+	for _, message := range messages {
+		record := kafka.Message{Topic: topic, Key: message.Key, Value: message.Value}
+		for key, value := range message.Headers {
+			record.Headers = append(record.Headers, kafka.Header{Key: key, Value: []byte(value)})
+		}
+		records = append(records, record)
+	}
+	return broker.writer.WriteMessages(ctx, records...)
+}
+
+func (broker *KafkaBroker) Close() error {
+	return broker.writer.Close()
+}
+
+// MemoryBroker keeps produced messages by topic, it stands in for Kafka in
+// tests and local runs.
+type MemoryBroker struct {
+	mutex  sync.Mutex
+	topics map[string][]Message
+	Fail   error
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{topics: map[string][]Message{}}
+}
+
+func (broker *MemoryBroker) Produce(_ context.Context, topic string, messages []Message) error {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if broker.Fail != nil {
+		return broker.Fail
+	}
+	broker.topics[topic] = append(broker.topics[topic], messages...)
+	return nil
+}
+
+func (broker *MemoryBroker) Messages(topic string) []Message {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	return append([]Message(nil), broker.topics[topic]...)
+}
+
+func (broker *MemoryBroker) Close() error {
+	return nil
+}
+
+// NewSink builds the sink chosen by RELAY_SINK, nil means relaying is off.
+func NewSink() (Sink, error) {
+	switch config.Cfg.Relay.Sink {
+	case "", "none":
+		return nil, nil
+	case "log":
+		return LogSink{}, nil
+	case "file":
+		return NewFileSink(config.Cfg.Relay.File)
+	case "kafka":
+		return BrokerSink{Broker: NewKafkaBroker(strings.Split(config.Cfg.Relay.Brokers, ",")), Topic: config.Cfg.Relay.Topic}, nil
+	case "memory":
+		return BrokerSink{Broker: NewMemoryBroker(), Topic: config.Cfg.Relay.Topic}, nil
+	}
+	return nil, fmt.Errorf("unknown relay sink %q", config.Cfg.Relay.Sink)
+}
+
+// RunDue relays every event waiting in the outbox and returns how many were
+// relayed.
+func RunDue(ctx context.Context, sink Sink, now time.Time) (int, error) {
+	count := 0
+	for {
+		relayed, err := models.RelayEvents(database.PostgresDB, now, relayBatch, relayLease, func(events []models.OutboxEvent) error {
+			ctx, cancel := context.WithTimeout(ctx, relayTimeout)
+			defer cancel()
+			return sink.Publish(ctx, events)
+		})
+		count += relayed
+		if err != nil || relayed < relayBatch {
+			return count, err
+		}
+	}
+}
+
+func Run(sink Sink, interval time.Duration) {
+	defer sink.Close()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := RunDue(context.Background(), sink, time.Now()); err != nil {
+			log.Printf("[relay] %v", err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"avito/config"
+	"avito/database"
+	"avito/models"
+	"log"
+	"time"
+)
+
+// RunDue executes every schedule that is due at now and returns how many ran.
+func RunDue(now time.Time) (int, error) {
+	count := 0
+	for {
+		found, err := models.RunDueTransfer(database.PostgresDB, now)
+		if err != nil || !found {
+			return count, err
+		}
+		count++
+	}
+}
+
+// GrantAllowances tops up every user not yet granted for the current period
+// and returns how many users were processed.
+func GrantAllowances(policy models.AllowancePolicy, now time.Time) (int, error) {
+	count := 0
+	for {
+		found, err := models.GrantAllowance(database.PostgresDB, policy, now)
+		if err != nil || !found {
+			return count, err
+		}
+		count++
+	}
+}
+
+func AllowancePolicy() models.AllowancePolicy {
+	return models.AllowancePolicy{
+		Period:     config.Cfg.Allowance.Period,
+		Amounts:    config.Cfg.Allowance.Amounts,
+		MaxBalance: config.Cfg.Allowance.MaxBalance,
+	}
+}
+
+// Reconcile checks the ledger and logs the discrepancies it finds.
+func Reconcile(freeze bool, now time.Time) (models.ReconciliationRun, error) {
+	run, err := models.Reconcile(database.PostgresDB, freeze, now)
+	if err != nil {
+		return run, err
+	}
+	for _, discrepancy := range run.Discrepancies {
+		log.Printf("[reconcile] %s: balance %v, ledger %v, suspicious transactions %v, purchases %v",
+			discrepancy.Username, discrepancy.Actual, discrepancy.Expected,
+			discrepancy.SuspiciousTransactions, discrepancy.SuspiciousPurchases)
+	}
+	if !run.Consistent() {
+		log.Printf("[reconcile] %d discrepancies, imbalance %v, frozen %v",
+			len(run.Discrepancies), run.Imbalance, run.FrozenUsers)
+	}
+	return run, nil
+}
+
+// Run executes due scheduled transfers and allowances every interval and
+// reconciles the ledger every reconcile interval. A failed job is left due
+// and retried on the next tick. It never returns.
+func Run(interval time.Duration) {
+	policy := AllowancePolicy()
+	var reconciled time.Time
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if config.Cfg.Reconcile.Interval > 0 && time.Since(reconciled) >= config.Cfg.Reconcile.Interval {
+			if _, err := Reconcile(config.Cfg.Reconcile.Freeze, time.Now()); err != nil {
+				log.Printf("[scheduler] reconcile failed: %v", err)
+			}
+			reconciled = time.Now()
+		}
+		count, err := RunDue(time.Now())
+		if err != nil {
+			log.Printf("[scheduler] run failed: %v", err)
+		}
+		if count > 0 {
+			log.Printf("[scheduler] executed %d scheduled transfers", count)
+		}
+		if !policy.Enabled() {
+			continue
+		}
+		count, err = GrantAllowances(policy, time.Now())
+		if err != nil {
+			log.Printf("[scheduler] allowance failed: %v", err)
+		}
+		if count > 0 {
+			log.Printf("[scheduler] granted allowance for %s to %d users", policy.PeriodKey(time.Now()), count)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package unit
+
+import (
+	"avito/database"
+	"avito/models"
+	"avito/scheduler"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAllowancePolicy(t *testing.T) {
+	policy := models.AllowancePolicy{
+		Period:     models.AllowanceMonthly,
+		Amounts:    map[string]float32{models.RoleUser: 100, models.RoleAdmin: 150},
+		MaxBalance: 1200,
+	}
+	now := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, "2025-03", policy.PeriodKey(now))
+	policy.Period = models.AllowanceWeekly
+	assert.Equal(t, "2025-W10", policy.PeriodKey(now))
+
+	//сумма зависит от роли и не поднимает баланс выше максимума
+	assert.Equal(t, float32(100), policy.TopUp(models.User{Role: models.RoleUser, Balance: 500}))
+	assert.Equal(t, float32(150), policy.TopUp(models.User{Role: models.RoleAdmin, Balance: 500}))
+	assert.Equal(t, float32(50), policy.TopUp(models.User{Role: models.RoleUser, Balance: 1150}))
+	assert.Equal(t, float32(0), policy.TopUp(models.User{Role: models.RoleUser, Balance: 1300}))
+}
+
+func TestGrantAllowances(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+
+	database.PostgresDB = db
+	userColumns := []string{"id", "created_at", "updated_at", "deleted_at", "username", "password", "balance", "role", "debt"}
+	pendingSQL := `SELECT \* FROM "users" WHERE \(role <> \$1 AND NOT frozen AND id NOT IN \(SELECT "user_id" FROM "allowance_grants" WHERE \(period = \$2 AND \(retry_at IS NULL OR retry_at > \$3\)\) AND "allowance_grants"."deleted_at" IS NULL\)\) AND "users"."deleted_at" IS NULL ORDER BY id LIMIT \$4 FOR UPDATE SKIP LOCKED`
+	policy := models.AllowancePolicy{Period: models.AllowanceMonthly, Amounts: map[string]float32{models.RoleUser: 100}}
+	now := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	grantSQL := `INSERT INTO "allowance_grants" (.+) ON CONFLICT \("user_id","period"\) DO UPDATE SET (.+) WHERE allowance_grants.retry_at IS NOT NULL RETURNING "id"`
+
+	t.Run("Should mint allowance from the mint account", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(pendingSQL).
+			WithArgs(models.RoleSystem, "2025-03", now, 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(2, time.Now(), time.Now(), nil, "bob", "", 500, models.RoleUser, 0))
+		mock.ExpectQuery(grantSQL).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		//сначала монеты зачисляются пользователю
+		mock.ExpectExec(`UPDATE "users" SET "balance"=balance \+ GREATEST\(\$1 - debt, 0\)`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT \* FROM "users" WHERE \(username = \$1 AND role = \$2\)`).
+			WithArgs(models.MintAccount, models.RoleSystem, 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(100, time.Now(), time.Now(), nil, models.MintAccount, "!", -5000, models.RoleSystem, 0))
+
+		//монеты списываются со счета эмиссии
+		mock.ExpectExec(`UPDATE "users" SET "balance"=balance \+ \$1,"updated_at"=\$2 WHERE \(username = \$3 AND role = \$4\)`).
+			WithArgs(float32(-100), sqlmock.AnyArg(), models.MintAccount, models.RoleSystem).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectChainHead(mock, "transactions")
+		mock.ExpectQuery(`INSERT INTO "transactions" (.+) VALUES (.+)`).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, uint(100), uint(2), float32(100), nil, nil, "", "allowance 2025-03", models.CategoryAllowance, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		expectOutbox(mock, models.EventTransactionCreated)
+		expectEvent(mock, models.EventCoinReceived)
+		mock.ExpectExec(`UPDATE "allowance_grants" SET "updated_at"=\$1,"transaction_id"=\$2`).
+			WithArgs(sqlmock.AnyArg(), uint(7), uint(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(pendingSQL).
+			WithArgs(models.RoleSystem, "2025-03", now, 1).
+			WillReturnRows(sqlmock.NewRows(userColumns))
+		mock.ExpectCommit()
+
+		count, err := scheduler.GrantAllowances(policy, now)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Should postpone a failing user and pay the next one", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(pendingSQL).
+			WithArgs(models.RoleSystem, "2025-03", now, 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(2, time.Now(), time.Now(), nil, "bob", "", 500, models.RoleUser, 0))
+		mock.ExpectQuery(grantSQL).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(`UPDATE "users" SET "balance"=balance \+ GREATEST\(\$1 - debt, 0\)`).
+			WillReturnError(errors.New("connection reset"))
+		mock.ExpectRollback()
+
+		//ошибка записывается, повтор откладывается
+		mock.ExpectQuery(`SELECT \* FROM "allowance_grants" WHERE \(user_id = \$1 AND period = \$2\)`).
+			WithArgs(2, "2025-03", 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "period", "failures"}))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "allowance_grants" (.+) VALUES (.+) RETURNING "id"`).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, uint(2), "2025-03", float32(0), nil,
+				1, now.Add(time.Minute), "connection reset").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		//следующий пользователь получает начисление
+		mock.ExpectBegin()
+		mock.ExpectQuery(pendingSQL).
+			WithArgs(models.RoleSystem, "2025-03", now, 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(3, time.Now(), time.Now(), nil, "carol", "", 1200, models.RoleUser, 0))
+		mock.ExpectQuery(grantSQL).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		mock.ExpectCommit()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(pendingSQL).
+			WithArgs(models.RoleSystem, "2025-03", now, 1).
+			WillReturnRows(sqlmock.NewRows(userColumns))
+		mock.ExpectCommit()
+
+		policy.MaxBalance = 1000
+		count, err := scheduler.GrantAllowances(policy, now)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+}
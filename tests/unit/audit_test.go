@@ -0,0 +1,110 @@
+package unit
+
+import (
+	"avito/controllers"
+	"avito/database"
+	"avito/middleware"
+	"avito/models"
+	"bytes"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func auditRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.RequestID, middleware.Audit)
+	router.POST("/api/sendCoin", func(context *gin.Context) {
+		context.Set("user_id", uint(7))
+		context.Set(controllers.AuditTargetKey, "bob")
+		context.JSON(http.StatusBadRequest, controllers.ErrorResponse{Error: "Insufficient funds"})
+	})
+	router.GET("/api/info", func(context *gin.Context) {
+		context.JSON(http.StatusOK, gin.H{})
+	})
+	router.GET("/api/buy/:item", func(context *gin.Context) {
+		context.Set(controllers.AuditTargetKey, context.Param("item"))
+		context.Status(http.StatusOK)
+	})
+	return router
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+	database.PostgresDB = db
+	router := auditRouter()
+	insertSQL := regexp.QuoteMeta(`INSERT INTO "audit_logs"`)
+
+	t.Run("Неуспешный перевод записывается с деталями ошибки", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(insertSQL).
+			WithArgs(sqlmock.AnyArg(), uint(7), "", "POST /api/sendCoin", "bob", "req-1", sqlmock.AnyArg(),
+				models.AuditFailure, http.StatusBadRequest, `{"error":"Insufficient funds"}`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/sendCoin", nil)
+		req.Header.Set(middleware.RequestIDHeader, "req-1")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "req-1", w.Header().Get(middleware.RequestIDHeader))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Чтение не записывается", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/info", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotEmpty(t, w.Header().Get(middleware.RequestIDHeader))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Покупка через GET записывается", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(insertSQL).
+			WithArgs(sqlmock.AnyArg(), nil, "", "GET /api/buy/:item", "pen", sqlmock.AnyArg(), sqlmock.AnyArg(),
+				models.AuditSuccess, http.StatusOK, "").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		mock.ExpectCommit()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/buy/pen", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestLoggerHidesAccessToken(t *testing.T) {
+	var logs bytes.Buffer
+	defaultWriter := gin.DefaultWriter
+	gin.DefaultWriter = &logs
+	defer func() { gin.DefaultWriter = defaultWriter }()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.Logger())
+	router.GET("/api/events", func(context *gin.Context) {
+		//обработчик по-прежнему видит токен
+		assert.Equal(t, "secret", context.Query("access_token"))
+		context.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/events?access_token=secret&since=5", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, logs.String(), "secret")
+	assert.Contains(t, logs.String(), "/api/events?access_token=REDACTED&since=5")
+}
@@ -3,6 +3,7 @@ package unit
 import (
 	"avito/controllers"
 	"avito/database"
+	"avito/models"
 	"bytes"
 	"database/sql"
 	"encoding/json"
@@ -111,7 +112,7 @@ func TestAuth(t *testing.T) {
 			WithArgs(user["username"], 1).
 			WillReturnError(gorm.ErrRecordNotFound)
 
-		expectedSQL = `INSERT INTO "users" \("created_at","updated_at","deleted_at","username","password","balance"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6\) (.+)`
+		expectedSQL = `INSERT INTO "users" \("created_at","updated_at","deleted_at","username","password","balance","role","debt","frozen"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6,\$7,\$8,\$9\) (.+)`
 		mock.ExpectBegin()
 		mock.ExpectQuery(expectedSQL).WillReturnError(gorm.ErrCheckConstraintViolated)
 
@@ -149,11 +150,24 @@ func TestAuth(t *testing.T) {
 			WithArgs(user["username"], 1).
 			WillReturnError(gorm.ErrRecordNotFound)
 
-		expectedSQL = `INSERT INTO "users" \("created_at","updated_at","deleted_at","username","password","balance"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6\) (.+)`
+		expectedSQL = `INSERT INTO "users" \("created_at","updated_at","deleted_at","username","password","balance","role","debt","frozen"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6,\$7,\$8,\$9\) (.+)`
 
 		addRow := rows.AddRow(1, time.Now(), time.Now(), nil, user["username"], hashedPass, defaultCoin)
 		mock.ExpectBegin()
 		mock.ExpectQuery(expectedSQL).WillReturnRows(addRow)
+
+		//приветственные монеты списываются со счета эмиссии
+		mock.ExpectQuery(`SELECT \* FROM "users" WHERE \(username = \$1 AND role = \$2\)`).
+			WithArgs(models.MintAccount, models.RoleSystem, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(100, models.MintAccount, models.RoleSystem))
+		mock.ExpectExec(`UPDATE "users" SET "balance"=balance \+ \$1,"updated_at"=\$2 WHERE \(username = \$3 AND role = \$4\)`).
+			WithArgs(float32(-defaultCoin), sqlmock.AnyArg(), models.MintAccount, models.RoleSystem).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectChainHead(mock, "transactions")
+		mock.ExpectQuery(`INSERT INTO "transactions" (.+) VALUES (.+)`).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, uint(100), uint(1), float32(defaultCoin), nil, nil, "", "welcome coins", models.CategoryWelcome, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		expectOutbox(mock, models.EventTransactionCreated)
 		mock.ExpectCommit()
 
 		gin.SetMode(gin.TestMode)
@@ -0,0 +1,78 @@
+package unit
+
+import (
+	"avito/catalog"
+	"avito/models"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func TestCatalogPlan(t *testing.T) {
+	deleted := gorm.DeletedAt{Time: time.Now(), Valid: true}
+	current := []models.Item{
+		{ItemName: "t-shirt", Price: 80},
+		{ItemName: "cup", Price: 20},
+		{ItemName: "pen", Price: 10},
+		{ItemName: "umbrella", Price: 200, Model: gorm.Model{DeletedAt: deleted}},
+		{ItemName: "wallet", Price: 50, Model: gorm.Model{DeletedAt: deleted}},
+	}
+
+	t.Run("Should report no changes for identical catalog", func(t *testing.T) {
+		desired := []models.Item{
+			{ItemName: "t-shirt", Price: 80},
+			{ItemName: "cup", Price: 20},
+			{ItemName: "pen", Price: 10},
+		}
+		diff := catalog.Plan(current, desired)
+		assert.True(t, diff.Empty())
+	})
+
+	t.Run("Should report added, changed and removed items", func(t *testing.T) {
+		desired := []models.Item{
+			{ItemName: "t-shirt", Price: 100},
+			{ItemName: "pen", Price: 10},
+			{ItemName: "umbrella", Price: 150},
+			{ItemName: "socks", Price: 10},
+		}
+		diff := catalog.Plan(current, desired)
+		// umbrella была удалена ранее и возвращается в каталог
+		assert.Equal(t, []string{"socks", "umbrella"}, diff.Added)
+		assert.Equal(t, []catalog.FieldChange{{ItemName: "t-shirt", Field: "price", Old: float32(80), New: float32(100)}}, diff.Changed)
+		// wallet уже удален и не попадает в removed
+		assert.Equal(t, []string{"cup"}, diff.Removed)
+	})
+
+	t.Run("Should report per-user limit change but ignore stock", func(t *testing.T) {
+		limit, stock := 1, 5
+		desired := []models.Item{
+			{ItemName: "t-shirt", Price: 80, PerUserLimit: &limit, Stock: &stock},
+			{ItemName: "cup", Price: 20},
+			{ItemName: "pen", Price: 10},
+		}
+		diff := catalog.Plan(current, desired)
+		assert.Equal(t, []catalog.FieldChange{{ItemName: "t-shirt", Field: "per_user_limit", Old: nil, New: 1}}, diff.Changed)
+	})
+
+	t.Run("Should report variant changes by sku", func(t *testing.T) {
+		price := float32(90)
+		withVariants := []models.Item{
+			{ItemName: "t-shirt", Price: 80, Variants: []models.ItemVariant{
+				{SKU: "t-shirt-s", Size: "S"},
+				{SKU: "t-shirt-m", Size: "M"},
+			}},
+		}
+		desired := []models.Item{
+			{ItemName: "t-shirt", Price: 80, Variants: []models.ItemVariant{
+				{SKU: "t-shirt-m", Size: "M", Price: &price},
+				{SKU: "t-shirt-l", Size: "L"},
+			}},
+		}
+		diff := catalog.Plan(withVariants, desired)
+		assert.Empty(t, diff.Changed)
+		assert.Equal(t, []string{"t-shirt-l"}, diff.Variants.Added)
+		assert.Equal(t, []catalog.FieldChange{{ItemName: "t-shirt", SKU: "t-shirt-m", Field: "price", Old: nil, New: float32(90)}}, diff.Variants.Changed)
+		assert.Equal(t, []string{"t-shirt-s"}, diff.Variants.Removed)
+	})
+}
@@ -0,0 +1,175 @@
+package unit
+
+import (
+	"avito/database"
+	"avito/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// expectChainHead ожидает блокировку цепочки и чтение хэша последней записи
+func expectChainHead(mock sqlmock.Sqlmock, table string, head ...string) {
+	rows := sqlmock.NewRows([]string{"hash"})
+	for _, hash := range head {
+		rows.AddRow(hash)
+	}
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT hash FROM ` + table + ` ORDER BY id DESC LIMIT 1`).WillReturnRows(rows)
+}
+
+func TestTransactionChain(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+	database.PostgresDB = db
+	createdAt := time.Date(2025, 3, 3, 10, 0, 0, 123456789, time.UTC)
+
+	t.Run("Новая запись ссылается на хэш предыдущей", func(t *testing.T) {
+		expectChainHead(mock, "transactions", "abc")
+		transaction := models.Transaction{SenderID: 1, ReceiverID: 2, Amount: 10}
+		transaction.CreatedAt = createdAt
+
+		assert.NoError(t, transaction.BeforeCreate(db))
+		assert.Equal(t, "abc", transaction.PrevHash)
+		assert.Len(t, transaction.Hash, 64)
+		assert.Equal(t, 123456000, transaction.CreatedAt.Nanosecond())
+		assert.Equal(t, transaction.CreatedAt, transaction.UpdatedAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Запись без хэша до включения цепочки не мешает новой", func(t *testing.T) {
+		mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT hash FROM transactions ORDER BY id DESC LIMIT 1`).
+			WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow(nil))
+		transaction := models.Transaction{SenderID: 1, ReceiverID: 2, Amount: 10}
+
+		assert.NoError(t, transaction.BeforeCreate(db))
+		assert.Equal(t, "", transaction.PrevHash)
+		assert.Len(t, transaction.Hash, 64)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	// цепочка: запись без хэша до включения цепочки и две связанные записи
+	expectChainHead(mock, "transactions")
+	first := models.Transaction{SenderID: 1, ReceiverID: 2, Amount: 10, Memo: "за пиццу", Category: models.CategoryPayback}
+	first.CreatedAt = createdAt
+	assert.NoError(t, first.BeforeCreate(db))
+	expectChainHead(mock, "transactions", first.Hash)
+	second := models.Transaction{SenderID: 2, ReceiverID: 3, Amount: 5, Category: models.CategoryGift}
+	second.CreatedAt = createdAt.Add(time.Minute)
+	assert.NoError(t, second.BeforeCreate(db))
+
+	columns := []string{"id", "created_at", "deleted_at", "sender_id", "receiver_id", "amount", "reversed_by_id", "memo",
+		"category", "prev_hash", "hash"}
+	chainRows := func(secondAmount float32, firstHash string) *sqlmock.Rows {
+		return sqlmock.NewRows(columns).
+			AddRow(1, createdAt.Add(-time.Hour), nil, 1, 2, 100, nil, "", models.CategoryOther, "", "").
+			AddRow(2, first.CreatedAt, nil, 1, 2, 10, nil, first.Memo, first.Category, "", firstHash).
+			AddRow(3, second.CreatedAt, nil, 2, 3, secondAmount, nil, "", second.Category, first.Hash, second.Hash)
+	}
+	transactionsSQL := `SELECT \* FROM "transactions" ORDER BY "transactions"."id" LIMIT \$1`
+	purchasesSQL := `SELECT \* FROM "purchases" ORDER BY "purchases"."id" LIMIT \$1`
+	//цепочка переводов начинается со второй записи
+	expectAnchors := func() {
+		mock.ExpectQuery(`SELECT \* FROM "chain_anchors"`).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "first_id"}).AddRow("transactions", 2).AddRow("purchases", 1))
+	}
+
+	t.Run("Целая цепочка проходит проверку", func(t *testing.T) {
+		expectAnchors()
+		mock.ExpectQuery(transactionsSQL).WillReturnRows(chainRows(5, first.Hash))
+		mock.ExpectQuery(purchasesSQL).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		reports, err := models.VerifyChains(db)
+		assert.NoError(t, err)
+		assert.True(t, reports[0].Intact())
+		assert.Equal(t, 1, reports[0].Legacy)
+		assert.Equal(t, 2, reports[0].Checked)
+		assert.Equal(t, uint(3), reports[0].HeadID)
+		assert.Equal(t, second.Hash, reports[0].HeadHash)
+		assert.True(t, reports[1].Intact())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Измененная сумма находится по содержимому", func(t *testing.T) {
+		expectAnchors()
+		mock.ExpectQuery(transactionsSQL).WillReturnRows(chainRows(500, first.Hash))
+		mock.ExpectQuery(purchasesSQL).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		reports, err := models.VerifyChains(db)
+		assert.NoError(t, err)
+		assert.False(t, reports[0].Intact())
+		assert.Equal(t, uint(3), *reports[0].BrokenID)
+		assert.Equal(t, models.ChainContentChanged, reports[0].Problem)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Подмененный хэш ломает следующее звено", func(t *testing.T) {
+		expectAnchors()
+		mock.ExpectQuery(transactionsSQL).WillReturnRows(chainRows(5, "forged"))
+		mock.ExpectQuery(purchasesSQL).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		reports, err := models.VerifyChains(db)
+		assert.NoError(t, err)
+		assert.Equal(t, uint(2), *reports[0].BrokenID)
+		assert.Equal(t, models.ChainContentChanged, reports[0].Problem)
+		assert.Equal(t, 0, reports[0].Checked)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Стертые хэши после якоря не выдаются за старые записи", func(t *testing.T) {
+		expectAnchors()
+		mock.ExpectQuery(transactionsSQL).WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, createdAt.Add(-time.Hour), nil, 1, 2, 100, nil, "", models.CategoryOther, "", "").
+			AddRow(2, first.CreatedAt, nil, 1, 2, 10, nil, first.Memo, first.Category, "", ""))
+		mock.ExpectQuery(purchasesSQL).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		reports, err := models.VerifyChains(db)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, reports[0].Legacy)
+		assert.Equal(t, uint(2), *reports[0].BrokenID)
+		assert.Equal(t, models.ChainHashMissing, reports[0].Problem)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Мягко удаленная запись ломает цепочку", func(t *testing.T) {
+		expectAnchors()
+		mock.ExpectQuery(transactionsSQL).WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(2, first.CreatedAt, createdAt.Add(time.Hour), 1, 2, 10, nil, first.Memo, first.Category, "", first.Hash))
+		mock.ExpectQuery(purchasesSQL).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		reports, err := models.VerifyChains(db)
+		assert.NoError(t, err)
+		assert.Equal(t, uint(2), *reports[0].BrokenID)
+		assert.Equal(t, models.ChainEntryDeleted, reports[0].Problem)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Подмена администратора отмены меняет хэш", func(t *testing.T) {
+		admin := uint(9)
+		expectChainHead(mock, "transactions")
+		reversal := models.Transaction{SenderID: 2, ReceiverID: 1, Amount: 10, ReversedByID: &admin}
+		reversal.CreatedAt = createdAt
+		assert.NoError(t, reversal.BeforeCreate(db))
+		rows := func(adminID uint) *sqlmock.Rows {
+			return sqlmock.NewRows(columns).AddRow(2, reversal.CreatedAt, nil, 2, 1, 10, adminID, "", "", "", reversal.Hash)
+		}
+
+		expectAnchors()
+		mock.ExpectQuery(transactionsSQL).WillReturnRows(rows(admin))
+		mock.ExpectQuery(purchasesSQL).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		reports, err := models.VerifyChains(db)
+		assert.NoError(t, err)
+		assert.True(t, reports[0].Intact())
+
+		expectAnchors()
+		mock.ExpectQuery(transactionsSQL).WillReturnRows(rows(10))
+		mock.ExpectQuery(purchasesSQL).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		reports, err = models.VerifyChains(db)
+		assert.NoError(t, err)
+		assert.Equal(t, models.ChainContentChanged, reports[0].Problem)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
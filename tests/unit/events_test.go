@@ -0,0 +1,151 @@
+package unit
+
+import (
+	"avito/controllers"
+	"avito/events"
+	"avito/models"
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type eventOfType string
+
+func (eventType eventOfType) Match(value driver.Value) bool {
+	var event models.Event
+	payload, ok := value.(string)
+	return ok && json.Unmarshal([]byte(payload), &event) == nil && event.Type == string(eventType)
+}
+
+// expectOutbox ожидает запись события нужного типа в outbox
+func expectOutbox(mock sqlmock.Sqlmock, eventType string) {
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock_shared\(\$1\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`INSERT INTO "outbox_events" \("created_at","type","user_id","data","webhooks_at","relayed_at"\)`).
+		WithArgs(sqlmock.AnyArg(), eventType, sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+}
+
+// expectEvent ожидает запись события в outbox и уведомление о нем
+func expectEvent(mock sqlmock.Sqlmock, eventType string) {
+	expectOutbox(mock, eventType)
+	mock.ExpectExec(`SELECT pg_notify\(\$1, \$2\)`).
+		WithArgs(models.EventsChannel, eventOfType(eventType)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func notification(t *testing.T, event models.Event) string {
+	payload, err := json.Marshal(event)
+	assert.NoError(t, err)
+	return string(payload)
+}
+
+func TestDispatchEvents(t *testing.T) {
+	alice := events.Subscribe(1)
+	defer alice.Close()
+	bob := events.Subscribe(2)
+	defer bob.Close()
+
+	t.Run("Событие получает только его пользователь", func(t *testing.T) {
+		events.Dispatch(notification(t, models.Event{Type: models.EventCoinReceived, UserID: 1}))
+
+		select {
+		case event := <-alice.Events:
+			assert.Equal(t, models.EventCoinReceived, event.Type)
+		case <-time.After(time.Second):
+			t.Fatal("alice did not get the event")
+		}
+		select {
+		case event := <-bob.Events:
+			t.Fatalf("bob got %v", event)
+		default:
+		}
+	})
+
+	t.Run("После отписки события не приходят", func(t *testing.T) {
+		bob.Close()
+		events.Dispatch(notification(t, models.Event{Type: models.EventCoinReceived, UserID: 2}))
+
+		select {
+		case event := <-bob.Events:
+			t.Fatalf("closed subscription got %v", event)
+		default:
+		}
+	})
+}
+
+func TestStreamEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/events", func(c *gin.Context) {
+		c.Set("user_id", uint(7))
+	}, controllers.StreamEvents)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// ждем подписку, затем публикуем событие для пользователя
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() (string, string) {
+		var name, data string
+		for {
+			line, err := reader.ReadString('\n')
+			assert.NoError(t, err)
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				name = line[len("event:"):]
+			case strings.HasPrefix(line, "data:"):
+				data = line[len("data:"):]
+			case line == "" && name != "":
+				return name, data
+			}
+		}
+	}
+	name, _ := readEvent()
+	assert.Equal(t, "ready", name)
+
+	events.Dispatch(notification(t, models.Event{Type: models.EventPurchaseCompleted, UserID: 7,
+		Data: map[string]interface{}{"item": "cup"}}))
+	name, data := readEvent()
+	assert.Equal(t, models.EventPurchaseCompleted, name)
+	assert.Contains(t, data, `"item":"cup"`)
+}
+
+func TestStreamEventsTokenExpiry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/events", func(c *gin.Context) {
+		c.Set("user_id", uint(8))
+		c.Set("token_expires_at", time.Now().Add(300*time.Millisecond))
+	}, controllers.StreamEvents)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/events")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	//после истечения токена приходит событие expired и поток закрывается
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "event:ready")
+	assert.Contains(t, string(body), "event:expired\ndata:{\"error\":\"token is expired\"}")
+}
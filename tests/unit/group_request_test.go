@@ -0,0 +1,172 @@
+package unit
+
+import (
+	"avito/controllers"
+	"avito/database"
+	"avito/models"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSplitShares(t *testing.T) {
+	//поровну, остаток достается первому
+	shares, err := models.SplitShares(100, []float32{0, 0, 0})
+	assert.NoError(t, err)
+	assert.Equal(t, []float32{33.34, 33.33, 33.33}, shares)
+
+	//явные доли должны давать в сумме total
+	shares, err = models.SplitShares(100, []float32{70, 30})
+	assert.NoError(t, err)
+	assert.Equal(t, []float32{70, 30}, shares)
+
+	_, err = models.SplitShares(100, []float32{70, 20})
+	assert.ErrorIs(t, err, models.ErrSharesMismatch)
+
+	_, err = models.SplitShares(100, []float32{70, 0})
+	assert.ErrorIs(t, err, models.ErrSharesMismatch)
+}
+
+func TestPayGroupRequest(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+
+	database.PostgresDB = db
+	userColumns := []string{"id", "created_at", "updated_at", "deleted_at", "username", "password", "balance", "role", "debt"}
+	requestColumns := []string{"id", "created_at", "updated_at", "deleted_at", "requester_id", "total", "collected", "memo", "category", "status"}
+	shareColumns := []string{"id", "created_at", "updated_at", "deleted_at", "group_request_id", "payer_id", "amount", "paid_at", "transaction_id"}
+	checkUserSQL := `SELECT \* FROM "users" WHERE ID = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+	lockRequestSQL := `SELECT \* FROM "group_requests" WHERE id = \$1 AND "group_requests"."deleted_at" IS NULL ORDER BY "group_requests"."id" LIMIT \$2 FOR UPDATE`
+	sharesSQL := `SELECT \* FROM "group_shares" WHERE group_request_id = \$1 AND "group_shares"."deleted_at" IS NULL ORDER BY id`
+	lockUsersSQL := `SELECT \* FROM "users" WHERE id IN \(\$1,\$2\) AND "users"."deleted_at" IS NULL ORDER BY id FOR UPDATE`
+
+	t.Run("Should fund group request with the last share", func(t *testing.T) {
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(uint(3), 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(3, time.Now(), time.Now(), nil, "carol", "", 1000, models.RoleUser, 0))
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockRequestSQL).
+			WithArgs("9", 1).
+			WillReturnRows(sqlmock.NewRows(requestColumns).
+				AddRow(9, time.Now(), time.Now(), nil, 1, 100, 50, "gift", models.CategoryGift, models.GroupRequestOpen))
+
+		//bob уже заплатил, осталась доля carol
+		mock.ExpectQuery(sharesSQL).
+			WithArgs(uint(9)).
+			WillReturnRows(sqlmock.NewRows(shareColumns).
+				AddRow(1, time.Now(), time.Now(), nil, 9, 2, 50, time.Now(), 11).
+				AddRow(2, time.Now(), time.Now(), nil, 9, 3, 50, nil, nil))
+		mock.ExpectQuery(lockUsersSQL).
+			WithArgs(uint(3), uint(1)).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(1, time.Now(), time.Now(), nil, "alice", "", 1000, models.RoleUser, 0).
+				AddRow(3, time.Now(), time.Now(), nil, "carol", "", 1000, models.RoleUser, 0))
+
+		//перевод через общую логику sendCoin
+		expectDebit(mock, 3, 50).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE "users" SET "balance"=balance \+ GREATEST\(\$1 - debt, 0\)`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectChainHead(mock, "transactions")
+		mock.ExpectQuery(`INSERT INTO "transactions" (.+) VALUES (.+)`).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, uint(3), uint(1), float32(50), nil, nil, "", "gift", models.CategoryGift, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+		expectOutbox(mock, models.EventTransactionCreated)
+		expectEvent(mock, models.EventCoinSent)
+		expectEvent(mock, models.EventCoinReceived)
+		mock.ExpectExec(`UPDATE "group_shares" SET "updated_at"=\$1,"paid_at"=\$2,"transaction_id"=\$3 WHERE "group_shares"."deleted_at" IS NULL AND "id" = \$4`).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), uint(12), uint(2)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE "group_requests" SET "updated_at"=\$1,"collected"=\$2,"status"=\$3,"funded_at"=\$4 WHERE "group_requests"."deleted_at" IS NULL AND "id" = \$5`).
+			WithArgs(sqlmock.AnyArg(), float32(100), models.GroupRequestFunded, sqlmock.AnyArg(), uint(9)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		//ответ в той же форме, что и GET
+		mock.ExpectQuery(`SELECT \* FROM "group_requests" WHERE id = \$1 AND "group_requests"."deleted_at" IS NULL ORDER BY "group_requests"."id" LIMIT \$2`).
+			WithArgs(uint(9), 1).
+			WillReturnRows(sqlmock.NewRows(requestColumns).
+				AddRow(9, time.Now(), time.Now(), nil, 1, 100, 100, "gift", models.CategoryGift, models.GroupRequestFunded))
+		mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"."id" = \$1`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(1, time.Now(), time.Now(), nil, "alice", "", 1050, models.RoleUser, 0))
+		mock.ExpectQuery(`SELECT \* FROM "group_shares" WHERE "group_shares"."group_request_id" = \$1`).
+			WithArgs(9).
+			WillReturnRows(sqlmock.NewRows(shareColumns).
+				AddRow(1, time.Now(), time.Now(), nil, 9, 2, 50, time.Now(), 11).
+				AddRow(2, time.Now(), time.Now(), nil, 9, 3, 50, time.Now(), 12))
+		mock.ExpectQuery(`SELECT \* FROM "users" WHERE "users"."id" IN \(\$1,\$2\)`).
+			WithArgs(2, 3).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(2, time.Now(), time.Now(), nil, "bob", "", 950, models.RoleUser, 0).
+				AddRow(3, time.Now(), time.Now(), nil, "carol", "", 950, models.RoleUser, 0))
+		mock.ExpectCommit()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodPost, "/", nil)
+		c.Set("user_id", uint(3))
+		c.Params = []gin.Param{{Key: "id", Value: "9"}}
+
+		controllers.PayGroupRequest(c)
+
+		if w.Code != http.StatusOK {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		var schema controllers.GroupRequestSchema
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &schema))
+		assert.Equal(t, "alice", schema.Requester)
+		assert.Equal(t, "carol", schema.Shares[1].User)
+		assert.Equal(t, models.GroupRequestFunded, schema.Status)
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Should not pay share twice", func(t *testing.T) {
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(uint(2), 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(2, time.Now(), time.Now(), nil, "bob", "", 1000, models.RoleUser, 0))
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockRequestSQL).
+			WithArgs("9", 1).
+			WillReturnRows(sqlmock.NewRows(requestColumns).
+				AddRow(9, time.Now(), time.Now(), nil, 1, 100, 50, "gift", models.CategoryGift, models.GroupRequestOpen))
+		mock.ExpectQuery(sharesSQL).
+			WithArgs(uint(9)).
+			WillReturnRows(sqlmock.NewRows(shareColumns).
+				AddRow(1, time.Now(), time.Now(), nil, 9, 2, 50, time.Now(), 11).
+				AddRow(2, time.Now(), time.Now(), nil, 9, 3, 50, nil, nil))
+		mock.ExpectRollback()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodPost, "/", nil)
+		c.Set("user_id", uint(2))
+		c.Params = []gin.Param{{Key: "id", Value: "9"}}
+
+		controllers.PayGroupRequest(c)
+
+		if w.Code != http.StatusConflict || w.Body.String() != `{"error":"Share is already paid"}` {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+}
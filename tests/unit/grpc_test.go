@@ -0,0 +1,172 @@
+package unit
+
+import (
+	"avito/config"
+	"avito/controllers"
+	"avito/database"
+	"avito/middleware"
+	"avito/models"
+	"avito/shoppb"
+	"avito/token"
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func shopClient(t *testing.T) shoppb.ShopServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(middleware.AuditRPC, middleware.AuthenticateRPC))
+	shoppb.RegisterShopServiceServer(server, controllers.ShopService{})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return shoppb.NewShopServiceClient(conn)
+}
+
+func TestShopService(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+	database.PostgresDB = db
+	client := shopClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	//без конфигурации токен истекает в ту же секунду
+	config.Cfg.Server.ExpirationMinutes = 60
+	signedToken, err := token.GenerateToken(models.User{ID: 5})
+	assert.NoError(t, err)
+	authorized := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+signedToken)
+	auditSQL := regexp.QuoteMeta(`INSERT INTO "audit_logs"`)
+	userSQL := `SELECT \* FROM "users" WHERE ID = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+
+	t.Run("Без токена закрытые методы недоступны", func(t *testing.T) {
+		_, err := client.GetInfo(ctx, &shoppb.GetInfoRequest{})
+
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Неверный токен отклоняется и записывается в аудит", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(auditSQL).
+			WithArgs(sqlmock.AnyArg(), nil, "", "gRPC "+shoppb.ShopService_SendCoin_FullMethodName, "bob",
+				"req-1", "", models.AuditFailure, int(codes.Unauthenticated), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		var header metadata.MD
+		callCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "broken", "x-request-id", "req-1")
+		_, err := client.SendCoin(callCtx, &shoppb.SendCoinRequest{ToUser: "bob", Amount: 10}, grpc.Header(&header))
+
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		assert.Equal(t, []string{"req-1"}, header.Get("x-request-id"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Перевод проходит ту же валидацию, что и в REST", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(auditSQL).
+			WithArgs(sqlmock.AnyArg(), uint(5), "", "gRPC "+shoppb.ShopService_SendCoin_FullMethodName, "bob",
+				sqlmock.AnyArg(), "", models.AuditFailure, int(codes.InvalidArgument), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		_, err := client.SendCoin(authorized, &shoppb.SendCoinRequest{ToUser: "bob", Amount: 10, Category: "bribe"})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "Category")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Ошибки бизнес-логики переводятся в коды gRPC", func(t *testing.T) {
+		mock.ExpectQuery(userSQL).
+			WithArgs(5, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "balance", "frozen"}).AddRow(5, "alice", 1000, true))
+		mock.ExpectBegin()
+		mock.ExpectQuery(auditSQL).
+			WithArgs(sqlmock.AnyArg(), uint(5), "", "gRPC "+shoppb.ShopService_BuyItem_FullMethodName, "pen",
+				sqlmock.AnyArg(), "", models.AuditFailure, int(codes.PermissionDenied), "Account is frozen").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		_, err := client.BuyItem(authorized, &shoppb.BuyItemRequest{Item: "pen"})
+
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Equal(t, "Account is frozen", status.Convert(err).Message())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Информация о пользователе", func(t *testing.T) {
+		mock.ExpectQuery(userSQL).
+			WithArgs(5, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "balance", "debt"}).AddRow(5, "alice", 900, 0))
+		mock.ExpectQuery(`SELECT items.item_name as type, count\(purchases.id\) as quantity FROM "purchases"`).
+			WillReturnRows(sqlmock.NewRows([]string{"type", "quantity"}).AddRow("pen", 2))
+		mock.ExpectQuery(`SELECT users.username as from_user.* coalesce\(users.role, ''\) <> \$2`).
+			WithArgs(5, models.RoleSystem).
+			WillReturnRows(sqlmock.NewRows([]string{"from_user", "amount", "memo", "category", "reversal"}).
+				AddRow("bob", 50, "lunch", "payback", false))
+		mock.ExpectQuery(`SELECT users.username as to_user`).
+			WillReturnRows(sqlmock.NewRows([]string{"to_user", "amount", "memo", "category", "reversal"}))
+
+		info, err := client.GetInfo(authorized, &shoppb.GetInfoRequest{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, float32(900), info.GetCoins())
+		assert.Equal(t, "pen", info.GetInventory()[0].GetType())
+		assert.Equal(t, uint64(2), info.GetInventory()[0].GetQuantity())
+		assert.Equal(t, "bob", info.GetCoinHistory().GetReceived()[0].GetFromUser())
+		assert.Empty(t, info.GetCoinHistory().GetSent())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Каталог доступен без токена", func(t *testing.T) {
+		updatedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery(`SELECT count\(\*\) FROM "items" CROSS JOIN LATERAL (.+) WHERE items.category = \$5`).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery(`SELECT "items"."id" FROM "items" (.+) WHERE items.category = \$5 (.+) ORDER BY prices.price DESC,items.item_name LIMIT \$6`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+		mock.ExpectQuery(`SELECT \* FROM "items" WHERE id IN \(\$1\)`).
+			WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "item_name", "price", "stock"}).
+				AddRow(5, updatedAt, updatedAt, nil, "hoody", 300, 4))
+		mock.ExpectQuery(`SELECT \* FROM "item_variants"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "item_id", "sku"}))
+		mock.ExpectQuery(`SELECT \* FROM "price_rules" WHERE (.+) ORDER BY id`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		items, err := client.ListItems(ctx, &shoppb.ListItemsRequest{Category: "merch", Sort: "price", Order: "desc", Limit: 1})
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), items.GetTotal())
+		assert.Len(t, items.GetItems(), 1)
+		assert.Equal(t, "hoody", items.GetItems()[0].GetName())
+		assert.Equal(t, int32(4), items.GetItems()[0].GetStock())
+		assert.Equal(t, updatedAt, items.GetItems()[0].GetUpdatedAt().AsTime())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Неверный запрос каталога", func(t *testing.T) {
+		_, err := client.ListItems(ctx, &shoppb.ListItemsRequest{Sort: "color"})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
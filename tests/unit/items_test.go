@@ -0,0 +1,211 @@
+package unit
+
+import (
+	"avito/config"
+	"avito/controllers"
+	"avito/database"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListItems(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+
+	database.PostgresDB = db
+	columns := []string{"id", "created_at", "updated_at", "deleted_at", "item_name", "price", "stock", "per_user_limit"}
+	variantColumns := []string{"id", "created_at", "updated_at", "deleted_at", "item_id", "sku", "size", "color", "price", "stock"}
+	ruleColumns := []string{"id", "created_at", "updated_at", "deleted_at", "name", "kind", "value", "items", "categories", "starts_at", "ends_at"}
+	priceRulesSQL := `SELECT \* FROM "price_rules" WHERE (.+) ORDER BY id`
+	updatedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	expectList := func() {
+		//цена, фильтры, сортировка и страница считаются в запросе
+		countSQL := `SELECT count\(\*\) FROM "items" CROSS JOIN LATERAL (.+) prices WHERE prices.price >= \$5 AND "items"."deleted_at" IS NULL`
+		mock.ExpectQuery(countSQL).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), float32(50)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		pageSQL := `SELECT "items"."id" FROM "items" CROSS JOIN LATERAL (.+) prices WHERE prices.price >= \$5 AND "items"."deleted_at" IS NULL ` +
+			`ORDER BY prices.price DESC,items.item_name LIMIT \$6`
+		mock.ExpectQuery(pageSQL).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), float32(50), 2).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6).AddRow(5))
+
+		selectSQL := `SELECT \* FROM "items" WHERE id IN \(\$1,\$2\) AND "items"."deleted_at" IS NULL`
+		mock.ExpectQuery(selectSQL).
+			WithArgs(6, 5).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(5, updatedAt, updatedAt, nil, "hoody", 300, nil, nil).
+				AddRow(6, updatedAt, updatedAt, nil, "pink-hoody", 500, 0, 1))
+
+		//у pink-hoody нет вариантов, у hoody есть размеры
+		variantsSQL := `SELECT \* FROM "item_variants" WHERE "item_variants"."item_id" IN \(\$1,\$2\) AND "item_variants"."deleted_at" IS NULL`
+		mock.ExpectQuery(variantsSQL).
+			WithArgs(5, 6).
+			WillReturnRows(sqlmock.NewRows(variantColumns).
+				AddRow(1, updatedAt, updatedAt, nil, 5, "hoody-m", "M", "black", nil, 0).
+				AddRow(2, updatedAt, updatedAt, nil, 5, "hoody-l", "L", "black", 320, nil))
+
+		//скидка 10% на hoody
+		mock.ExpectQuery(priceRulesSQL).
+			WillReturnRows(sqlmock.NewRows(ruleColumns).
+				AddRow(1, updatedAt, updatedAt, nil, "friday", "percent", 10, `["hoody"]`, nil, updatedAt, nil))
+	}
+
+	t.Run("Should not bind incorrect sort", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodGet, "/?sort=color", nil)
+
+		controllers.ListItems(c)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	var etag string
+	t.Run("Should list filtered and sorted items", func(t *testing.T) {
+		expectList()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodGet, "/?min_price=50&sort=price&order=desc&limit=2", nil)
+
+		controllers.ListItems(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body controllers.ItemsSchema
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, int64(2), body.Total)
+		assert.Equal(t, "pink-hoody", body.Items[0].Name)
+		assert.False(t, body.Items[0].Available)
+		assert.True(t, body.Items[1].Available)
+		assert.Equal(t, float32(270), body.Items[1].Price)
+		assert.Equal(t, float32(300), body.Items[1].RegularPrice)
+		assert.Equal(t, "friday", body.Items[1].Sale.Name)
+		soldOut := 0
+		assert.Equal(t, []controllers.VariantSchema{
+			{SKU: "hoody-m", Size: "M", Color: "black", Price: 270, RegularPrice: 300, Available: false, Stock: &soldOut},
+			{SKU: "hoody-l", Size: "L", Color: "black", Price: 288, RegularPrice: 320, Available: true},
+		}, body.Items[1].Variants)
+
+		etag = w.Header().Get("ETag")
+		assert.NotEmpty(t, etag)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Should return 304 for matching ETag", func(t *testing.T) {
+		expectList()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodGet, "/?min_price=50&sort=price&order=desc&limit=2", nil)
+		c.Request.Header.Set("If-None-Match", etag)
+
+		controllers.ListItems(c)
+
+		assert.Equal(t, http.StatusNotModified, w.Code)
+		assert.Empty(t, w.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Should return localized item", func(t *testing.T) {
+		config.Cfg.Catalog.ImagesBaseURL = "/api/images/"
+		richColumns := append(columns, "category", "tags", "title", "description", "images")
+		selectSQL := `SELECT \* FROM "items" WHERE item_name = \$1 AND "items"."deleted_at" IS NULL ORDER BY "items"."id" LIMIT \$2`
+		mock.ExpectQuery(selectSQL).
+			WithArgs("cup", 1).
+			WillReturnRows(sqlmock.NewRows(richColumns).
+				AddRow(2, updatedAt, updatedAt, nil, "cup", 20, nil, nil, "kitchen", `["merch"]`,
+					`{"en":"Cup","ru":"Кружка"}`, `{"en":"Ceramic cup"}`, `["cup.svg"]`))
+		variantsSQL := `SELECT \* FROM "item_variants" WHERE "item_variants"."item_id" = \$1 AND "item_variants"."deleted_at" IS NULL`
+		mock.ExpectQuery(variantsSQL).
+			WithArgs(2).
+			WillReturnRows(sqlmock.NewRows(variantColumns))
+		mock.ExpectQuery(priceRulesSQL).
+			WillReturnRows(sqlmock.NewRows(ruleColumns))
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodGet, "/", nil)
+		c.Request.Header.Set("Accept-Language", "ru-RU,ru;q=0.9,en;q=0.8")
+		c.Params = []gin.Param{{Key: "item", Value: "cup"}}
+
+		controllers.GetItem(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body controllers.ItemSchema
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, "Кружка", body.Title)
+		// описания на русском нет, используется английское
+		assert.Equal(t, "Ceramic cup", body.Description)
+		assert.Equal(t, []string{"merch"}, body.Tags)
+		assert.Equal(t, []string{"/api/images/cup.svg"}, body.Images)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestSetItemStock(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+	database.PostgresDB = db
+	gin.SetMode(gin.TestMode)
+	updateSQL := `UPDATE "items" SET "stock"=\$1 WHERE item_name = \$2 AND "items"."deleted_at" IS NULL`
+
+	setStock := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+		c.Params = []gin.Param{{Key: "item", Value: "pink-hoody"}}
+		controllers.SetItemStock(c)
+		return w
+	}
+
+	t.Run("Отрицательный остаток не принимается", func(t *testing.T) {
+		w := setStock(`{"stock": -1}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Остаток задается", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(updateSQL).
+			WithArgs(50, "pink-hoody").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		w := setStock(`{"stock": 50}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("null снимает ограничение остатка", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec(updateSQL).
+			WithArgs(nil, "pink-hoody").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		w := setStock(`{"stock": null}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,134 @@
+package unit
+
+import (
+	"avito/controllers"
+	"avito/database"
+	"avito/events"
+	"avito/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLive(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+	database.PostgresDB = db
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/api/live", func(c *gin.Context) {
+		c.Set("user_id", uint(5))
+	}, controllers.Live)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	userSQL := `SELECT \* FROM "users" WHERE (.+)"users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+	userRow := func(balance float32) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "username", "balance", "debt"}).AddRow(5, "alice", balance, 0)
+	}
+	mock.ExpectQuery(userSQL).WillReturnRows(userRow(1000))
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/live", nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	read := func() controllers.LiveMessage {
+		var message controllers.LiveMessage
+		assert.NoError(t, conn.ReadJSON(&message))
+		return message
+	}
+
+	t.Run("При подключении приходит баланс", func(t *testing.T) {
+		message := read()
+		assert.Equal(t, "balance", message.Type)
+		assert.Equal(t, float32(1000), *message.Balance)
+	})
+
+	t.Run("После события приходит событие и новый баланс", func(t *testing.T) {
+		mock.ExpectQuery(userSQL).WillReturnRows(userRow(1100))
+		events.Dispatch(notification(t, models.Event{Type: models.EventCoinReceived, UserID: 5}))
+
+		message := read()
+		assert.Equal(t, "event", message.Type)
+		assert.Equal(t, models.EventCoinReceived, message.Event.Type)
+		message = read()
+		assert.Equal(t, "balance", message.Type)
+		assert.Equal(t, float32(1100), *message.Balance)
+	})
+
+	t.Run("Перевод проходит ту же валидацию", func(t *testing.T) {
+		assert.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "send", "id": "1", "amount": 10}))
+
+		message := read()
+		assert.Equal(t, "result", message.Type)
+		assert.Equal(t, "1", message.ID)
+		assert.Equal(t, http.StatusBadRequest, message.Status)
+		assert.Contains(t, message.Error, "ToUser")
+	})
+
+	t.Run("Неизвестная команда", func(t *testing.T) {
+		assert.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "withdraw", "id": "2"}))
+
+		message := read()
+		assert.Equal(t, "2", message.ID)
+		assert.Equal(t, http.StatusBadRequest, message.Status)
+		assert.Equal(t, "Unknown command", message.Error)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLiveLimits(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+	database.PostgresDB = db
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/api/live", func(c *gin.Context) {
+		c.Set("user_id", uint(5))
+		c.Set("token_expires_at", time.Now().Add(300*time.Millisecond))
+	}, controllers.Live)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	userSQL := `SELECT \* FROM "users" WHERE (.+)"users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+	dial := func() *websocket.Conn {
+		mock.ExpectQuery(userSQL).WillReturnRows(sqlmock.NewRows([]string{"id", "username", "balance"}).AddRow(5, "alice", 1000))
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/live", nil)
+		assert.NoError(t, err)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var message controllers.LiveMessage
+		assert.NoError(t, conn.ReadJSON(&message))
+		return conn
+	}
+
+	t.Run("Слишком большая команда закрывает соединение", func(t *testing.T) {
+		conn := dial()
+		defer conn.Close()
+
+		assert.NoError(t, conn.WriteJSON(map[string]interface{}{"type": "send", "memo": strings.Repeat("a", 10000)}))
+
+		var message controllers.LiveMessage
+		assert.True(t, websocket.IsCloseError(conn.ReadJSON(&message), websocket.CloseMessageTooBig))
+	})
+
+	t.Run("Соединение закрывается, когда истекает токен", func(t *testing.T) {
+		conn := dial()
+		defer conn.Close()
+
+		var message controllers.LiveMessage
+		err := conn.ReadJSON(&message)
+		assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+		assert.Contains(t, err.Error(), "token is expired")
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,135 @@
+package unit
+
+import (
+	"avito/controllers"
+	"avito/database"
+	"avito/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPaymentRequest(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+
+	database.PostgresDB = db
+	userColumns := []string{"id", "created_at", "updated_at", "deleted_at", "username", "password", "balance", "role", "debt"}
+	requestColumns := []string{"id", "created_at", "updated_at", "deleted_at", "requester_id", "payer_id", "amount", "memo", "category", "status", "expires_at"}
+	checkUserSQL := `SELECT \* FROM "users" WHERE ID = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+	lockRequestSQL := `SELECT \* FROM "payment_requests" WHERE \(id = \$1 AND payer_id = \$2\) AND "payment_requests"."deleted_at" IS NULL ORDER BY "payment_requests"."id" LIMIT \$3 FOR UPDATE`
+	lockUsersSQL := `SELECT \* FROM "users" WHERE id IN \(\$1,\$2\) AND "users"."deleted_at" IS NULL ORDER BY id FOR UPDATE`
+
+	t.Run("Should not accept expired payment request", func(t *testing.T) {
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(uint(2), 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(2, time.Now(), time.Now(), nil, "payer", "", 1000, models.RoleUser, 0))
+		mock.ExpectBegin()
+
+		//срок запроса истек час назад
+		mock.ExpectQuery(lockRequestSQL).
+			WithArgs("4", uint(2), 1).
+			WillReturnRows(sqlmock.NewRows(requestColumns).
+				AddRow(4, time.Now(), time.Now(), nil, 1, 2, 50, "lunch", "payback", models.PaymentRequestPending, time.Now().Add(-time.Hour)))
+		mock.ExpectRollback()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodPost, "/", nil)
+		c.Set("user_id", uint(2))
+		c.Params = []gin.Param{{Key: "id", Value: "4"}}
+
+		controllers.AcceptPaymentRequest(c)
+
+		if w.Code != http.StatusConflict || w.Body.String() != `{"error":"Payment request is expired"}` {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Should not accept payment request without enough coins", func(t *testing.T) {
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(uint(2), 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(2, time.Now(), time.Now(), nil, "payer", "", 10, models.RoleUser, 0))
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockRequestSQL).
+			WithArgs("4", uint(2), 1).
+			WillReturnRows(sqlmock.NewRows(requestColumns).
+				AddRow(4, time.Now(), time.Now(), nil, 1, 2, 50, "lunch", "payback", models.PaymentRequestPending, time.Now().Add(time.Hour)))
+
+		//у плательщика 10 монет из 50
+		mock.ExpectQuery(lockUsersSQL).
+			WithArgs(uint(2), uint(1)).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(1, time.Now(), time.Now(), nil, "requester", "", 1000, models.RoleUser, 0).
+				AddRow(2, time.Now(), time.Now(), nil, "payer", "", 10, models.RoleUser, 0))
+		mock.ExpectRollback()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodPost, "/", nil)
+		c.Set("user_id", uint(2))
+		c.Params = []gin.Param{{Key: "id", Value: "4"}}
+
+		controllers.AcceptPaymentRequest(c)
+
+		if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":"Insufficient funds to complete the transaction"}` {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Should decline payment request", func(t *testing.T) {
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(uint(2), 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(2, time.Now(), time.Now(), nil, "payer", "", 1000, models.RoleUser, 0))
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockRequestSQL).
+			WithArgs("4", uint(2), 1).
+			WillReturnRows(sqlmock.NewRows(requestColumns).
+				AddRow(4, time.Now(), time.Now(), nil, 1, 2, 50, "lunch", "payback", models.PaymentRequestPending, time.Now().Add(time.Hour)))
+		mock.ExpectExec(`UPDATE "payment_requests" SET "updated_at"=\$1,"status"=\$2,"responded_at"=\$3,"transaction_id"=\$4 WHERE "payment_requests"."deleted_at" IS NULL AND "id" = \$5`).
+			WithArgs(sqlmock.AnyArg(), models.PaymentRequestDeclined, sqlmock.AnyArg(), nil, uint(4)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectEvent(mock, models.EventPaymentRequestDeclined)
+		mock.ExpectCommit()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodPost, "/", nil)
+		c.Set("user_id", uint(2))
+		c.Params = []gin.Param{{Key: "id", Value: "4"}}
+
+		controllers.DeclinePaymentRequest(c)
+
+		if w.Code != http.StatusOK {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+}
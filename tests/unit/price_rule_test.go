@@ -0,0 +1,57 @@
+package unit
+
+import (
+	"avito/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestResolvePrice(t *testing.T) {
+	now := time.Now()
+	lastWeek := now.Add(-7 * 24 * time.Hour)
+	yesterday := now.Add(-24 * time.Hour)
+	hoody := models.Item{ItemName: "hoody", Price: 300, Category: "clothes"}
+
+	t.Run("Правило price может поднять цену", func(t *testing.T) {
+		raise := models.PriceRule{ID: 1, Kind: models.RuleSetPrice, Value: 350, StartsAt: yesterday}
+
+		price, rule := models.ResolvePrice(&hoody, nil, []models.PriceRule{raise}, now)
+
+		assert.Equal(t, float32(350), price)
+		assert.Equal(t, uint(1), rule.ID)
+	})
+
+	t.Run("Из правил price берется начавшееся последним", func(t *testing.T) {
+		old := models.PriceRule{ID: 1, Kind: models.RuleSetPrice, Value: 250, StartsAt: lastWeek}
+		raise := models.PriceRule{ID: 2, Kind: models.RuleSetPrice, Value: 350, StartsAt: yesterday}
+
+		price, rule := models.ResolvePrice(&hoody, nil, []models.PriceRule{raise, old}, now)
+
+		assert.Equal(t, float32(350), price)
+		assert.Equal(t, uint(2), rule.ID)
+	})
+
+	t.Run("Скидки считаются от новой цены, берется самая низкая", func(t *testing.T) {
+		raise := models.PriceRule{ID: 1, Kind: models.RuleSetPrice, Value: 400, StartsAt: yesterday}
+		percent := models.PriceRule{ID: 2, Kind: models.DiscountPercent, Value: 10, StartsAt: yesterday}
+		fixed := models.PriceRule{ID: 3, Kind: models.DiscountFixed, Value: 50, StartsAt: yesterday}
+		rules := []models.PriceRule{raise, percent, fixed}
+
+		base, _ := models.BasePrice(&hoody, nil, rules, now)
+		price, rule := models.ResolvePrice(&hoody, nil, rules, now)
+
+		assert.Equal(t, float32(400), base)
+		assert.Equal(t, float32(350), price)
+		assert.Equal(t, uint(3), rule.ID)
+	})
+
+	t.Run("Без правил действует цена каталога", func(t *testing.T) {
+		ended := models.PriceRule{ID: 1, Kind: models.RuleSetPrice, Value: 350, StartsAt: lastWeek, EndsAt: &yesterday}
+
+		price, rule := models.ResolvePrice(&hoody, nil, []models.PriceRule{ended}, now)
+
+		assert.Equal(t, float32(300), price)
+		assert.Nil(t, rule)
+	})
+}
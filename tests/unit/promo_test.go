@@ -0,0 +1,43 @@
+package unit
+
+import (
+	"avito/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPromoCode(t *testing.T) {
+	now := time.Now()
+	tomorrow := now.Add(24 * time.Hour)
+	hoody := models.Item{ItemName: "hoody", Price: 300, Category: "clothes"}
+	cup := models.Item{ItemName: "cup", Price: 20, Category: "kitchen"}
+
+	t.Run("Should calculate percent and fixed discounts", func(t *testing.T) {
+		percent := models.PromoCode{Kind: models.DiscountPercent, Value: 20}
+		fixed := models.PromoCode{Kind: models.DiscountFixed, Value: 50}
+
+		assert.Equal(t, float32(60), percent.Discount(300))
+		assert.Equal(t, float32(50), fixed.Discount(300))
+		// скидка не может быть больше цены
+		assert.Equal(t, float32(20), fixed.Discount(20))
+	})
+
+	t.Run("Should apply only to listed items and categories", func(t *testing.T) {
+		clothes := models.PromoCode{Kind: models.DiscountFixed, Value: 5, Categories: []string{"clothes"}}
+		everything := models.PromoCode{Kind: models.DiscountFixed, Value: 5}
+
+		assert.NoError(t, clothes.Check(&hoody, now))
+		assert.ErrorIs(t, clothes.Check(&cup, now), models.ErrPromoNotApplicable)
+		assert.NoError(t, everything.Check(&cup, now))
+	})
+
+	t.Run("Should respect validity window", func(t *testing.T) {
+		future := models.PromoCode{Kind: models.DiscountFixed, Value: 5, ValidFrom: &tomorrow}
+		expired := models.PromoCode{Kind: models.DiscountFixed, Value: 5, ValidUntil: &now}
+
+		assert.ErrorIs(t, future.Check(&hoody, now), models.ErrPromoNotActive)
+		assert.ErrorIs(t, expired.Check(&hoody, now), models.ErrPromoNotActive)
+		assert.NoError(t, future.Check(&hoody, tomorrow))
+	})
+}
@@ -35,6 +35,11 @@ func TestBuyItem(t *testing.T) {
 	users := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "username", "password", "balance"})
 	purchases := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "item_id", "user_id", "price"})
 	items := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "item_name", "price"})
+	variants := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "item_id", "sku", "size", "color", "price", "stock"})
+	checkVariantsSQL := `SELECT \* FROM "item_variants" WHERE "item_variants"."item_id" = \$1 AND "item_variants"."deleted_at" IS NULL`
+	priceRules := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "name", "kind", "value", "items", "categories", "starts_at", "ends_at"})
+	priceRulesSQL := `SELECT \* FROM "price_rules" WHERE \(starts_at <= \$1 AND \(ends_at IS NULL OR ends_at > \$2\)\) AND "price_rules"."deleted_at" IS NULL ORDER BY id`
+	shopRevenueSQL := `UPDATE "users" SET "balance"=balance \+ \$1,"updated_at"=\$2 WHERE \(username = \$3 AND role = \$4\)`
 
 	t.Run("Should not authorize due to wrong token", func(t *testing.T) {
 
@@ -135,6 +140,10 @@ func TestBuyItem(t *testing.T) {
 		mock.ExpectQuery(checkItemSQL).
 			WithArgs(item.ItemName, 1).
 			WillReturnRows(addedItem)
+		mock.ExpectQuery(checkVariantsSQL).
+			WithArgs(item.ID).
+			WillReturnRows(variants)
+		mock.ExpectQuery(priceRulesSQL).WillReturnRows(priceRules)
 
 		gin.SetMode(gin.TestMode)
 
@@ -173,16 +182,14 @@ func TestBuyItem(t *testing.T) {
 		mock.ExpectQuery(checkItemSQL).
 			WithArgs(item.ItemName, 1).
 			WillReturnRows(addedItem)
+		mock.ExpectQuery(checkVariantsSQL).
+			WithArgs(item.ID).
+			WillReturnRows(variants)
+		mock.ExpectQuery(priceRulesSQL).WillReturnRows(priceRules)
 
-		//транзация покупки и изменение баланса не пройдет
-		purchaseSQL := `INSERT INTO "purchases" \("created_at","updated_at","deleted_at","item_id","user_id","price"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6\) (.+)`
-		updateBalanceSQL := `UPDATE "users" SET "balance"=\$1,"updated_at"=\$2 WHERE "users"."deleted_at" IS NULL AND "id" = \$3`
-
-		addedPurchase := purchases.AddRow(1, time.Now(), time.Now(), nil, item.ID, user.ID, item.Price)
-
+		//изменение баланса не пройдет, покупка не записывается
 		mock.ExpectBegin()
-		mock.ExpectQuery(purchaseSQL).WillReturnRows(addedPurchase)
-		mock.ExpectExec(updateBalanceSQL).WillReturnError(gorm.ErrInvalidTransaction)
+		expectDebit(mock, user.ID, item.Price).WillReturnError(gorm.ErrInvalidTransaction)
 		// не ожидается коммит
 
 		gin.SetMode(gin.TestMode)
@@ -222,16 +229,348 @@ func TestBuyItem(t *testing.T) {
 		mock.ExpectQuery(checkItemSQL).
 			WithArgs(item.ItemName, 1).
 			WillReturnRows(addedItem)
+		mock.ExpectQuery(checkVariantsSQL).
+			WithArgs(item.ID).
+			WillReturnRows(variants)
+		mock.ExpectQuery(priceRulesSQL).WillReturnRows(priceRules)
 
-		//транзация покупки и изменение баланса
-		purchaseSQL := `INSERT INTO "purchases" \("created_at","updated_at","deleted_at","item_id","user_id","price"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6\) (.+)`
-		updateBalanceSQL := `UPDATE "users" SET "balance"=\$1,"updated_at"=\$2 WHERE "users"."deleted_at" IS NULL AND "id" = \$3`
+		//изменение баланса и транзация покупки
+		purchaseSQL := `INSERT INTO "purchases" \("created_at","updated_at","deleted_at","item_id","user_id","price","variant_id","list_price","discount","promo_code_id","price_rule_id","returned_at","prev_hash","hash"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6,\$7,\$8,\$9,\$10,\$11,\$12,\$13,\$14\) (.+)`
 
 		addedPurchase := purchases.AddRow(1, time.Now(), time.Now(), nil, item.ID, user.ID, item.Price)
 
 		mock.ExpectBegin()
+		expectDebit(mock, user.ID, item.Price).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(shopRevenueSQL).
+			WithArgs(item.Price, sqlmock.AnyArg(), models.ShopAccount, models.RoleSystem).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectChainHead(mock, "purchases")
 		mock.ExpectQuery(purchaseSQL).WillReturnRows(addedPurchase)
-		mock.ExpectExec(updateBalanceSQL).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectOutbox(mock, models.EventPurchaseCreated)
+		expectEvent(mock, models.EventPurchaseCompleted)
+		mock.ExpectCommit()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodGet, "/", nil)
+		c.Set("user_id", user.ID)
+
+		c.Params = []gin.Param{gin.Param{Key: "item", Value: item.ItemName}}
+
+		controllers.BuyItem(c)
+
+		if w.Code != http.StatusOK {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+
+	})
+
+	t.Run("Should return 400 due to sold out item", func(t *testing.T) {
+		addedUser := users.AddRow(user.ID, time.Now(), time.Now(), nil, user.Username, user.Password, defaultCoin)
+		limitedItems := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "item_name", "price", "stock", "per_user_limit"}).
+			AddRow(item.ID, time.Now(), time.Now(), nil, item.ItemName, item.Price, 0, nil)
+
+		checkUserSQL := `SELECT \* FROM "users" WHERE ID = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(user.ID, 1).
+			WillReturnRows(addedUser)
+
+		//товар закончился, транзакция не начинается
+		checkItemSQL := `SELECT \* FROM "items" WHERE item_name = \$1 AND "items"."deleted_at" IS NULL ORDER BY "items"."id" LIMIT \$2`
+		mock.ExpectQuery(checkItemSQL).
+			WithArgs(item.ItemName, 1).
+			WillReturnRows(limitedItems)
+		mock.ExpectQuery(checkVariantsSQL).
+			WithArgs(item.ID).
+			WillReturnRows(variants)
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodGet, "/", nil)
+		c.Set("user_id", user.ID)
+
+		c.Params = []gin.Param{gin.Param{Key: "item", Value: item.ItemName}}
+
+		controllers.BuyItem(c)
+
+		if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":"Item is sold out"}` {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+
+	})
+
+	t.Run("Should return 400 due to reached purchase limit", func(t *testing.T) {
+		addedUser := users.AddRow(user.ID, time.Now(), time.Now(), nil, user.Username, user.Password, defaultCoin)
+		limitedItems := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "item_name", "price", "stock", "per_user_limit"}).
+			AddRow(item.ID, time.Now(), time.Now(), nil, item.ItemName, item.Price, 10, 1)
+
+		checkUserSQL := `SELECT \* FROM "users" WHERE ID = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(user.ID, 1).
+			WillReturnRows(addedUser)
+
+		checkItemSQL := `SELECT \* FROM "items" WHERE item_name = \$1 AND "items"."deleted_at" IS NULL ORDER BY "items"."id" LIMIT \$2`
+		mock.ExpectQuery(checkItemSQL).
+			WithArgs(item.ItemName, 1).
+			WillReturnRows(limitedItems)
+		mock.ExpectQuery(checkVariantsSQL).
+			WithArgs(item.ID).
+			WillReturnRows(variants)
+		mock.ExpectQuery(priceRulesSQL).WillReturnRows(priceRules)
+
+		//блокировка user'а и подсчет уже купленных, лимит исчерпан
+		lockUserSQL := `SELECT "id" FROM "users" WHERE id = \$1 (.+) FOR UPDATE`
+		countPurchasesSQL := `SELECT count\(\*\) FROM "purchases" WHERE \(user_id = \$1 AND item_id = \$2 AND returned_at IS NULL\)`
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockUserSQL).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(user.ID))
+		mock.ExpectQuery(countPurchasesSQL).
+			WithArgs(user.ID, item.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodGet, "/", nil)
+		c.Set("user_id", user.ID)
+
+		c.Params = []gin.Param{gin.Param{Key: "item", Value: item.ItemName}}
+
+		controllers.BuyItem(c)
+
+		if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":"Purchase limit for this item is reached"}` {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+
+	})
+
+	t.Run("Should return 400 due to not specified variant", func(t *testing.T) {
+		addedUser := users.AddRow(user.ID, time.Now(), time.Now(), nil, user.Username, user.Password, defaultCoin)
+		addedItem := items.AddRow(item.ID, time.Now(), time.Now(), nil, item.ItemName, item.Price)
+		sizes := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "item_id", "sku", "size", "color", "price", "stock"}).
+			AddRow(1, time.Now(), time.Now(), nil, item.ID, "t-shirt-m", "M", "white", nil, nil).
+			AddRow(2, time.Now(), time.Now(), nil, item.ID, "t-shirt-l", "L", "white", nil, nil)
+
+		checkUserSQL := `SELECT \* FROM "users" WHERE ID = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(user.ID, 1).
+			WillReturnRows(addedUser)
+
+		checkItemSQL := `SELECT \* FROM "items" WHERE item_name = \$1 AND "items"."deleted_at" IS NULL ORDER BY "items"."id" LIMIT \$2`
+		mock.ExpectQuery(checkItemSQL).
+			WithArgs(item.ItemName, 1).
+			WillReturnRows(addedItem)
+
+		//у товара есть размеры, но цвет white подходит обоим
+		mock.ExpectQuery(checkVariantsSQL).
+			WithArgs(item.ID).
+			WillReturnRows(sizes)
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodGet, "/?color=white", nil)
+		c.Set("user_id", user.ID)
+
+		c.Params = []gin.Param{gin.Param{Key: "item", Value: item.ItemName}}
+
+		controllers.BuyItem(c)
+
+		if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":"Item variant must be specified"}` {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+
+	})
+
+	t.Run("Should return 400 when item has variants but no default one", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT \* FROM "users" WHERE ID = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`).
+			WithArgs(user.ID, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "balance"}).AddRow(user.ID, user.Username, defaultCoin))
+		mock.ExpectQuery(`SELECT \* FROM "items" WHERE item_name = \$1 AND "items"."deleted_at" IS NULL ORDER BY "items"."id" LIMIT \$2`).
+			WithArgs(item.ItemName, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "item_name", "price"}).AddRow(item.ID, item.ItemName, item.Price))
+		mock.ExpectQuery(checkVariantsSQL).
+			WithArgs(item.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "item_id", "sku", "size", "stock"}).
+				AddRow(1, item.ID, "t-shirt-m", "M", 5).
+				AddRow(2, item.ID, "t-shirt-l", "L", 5))
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodGet, "/", nil)
+		c.Set("user_id", user.ID)
+
+		c.Params = []gin.Param{gin.Param{Key: "item", Value: item.ItemName}}
+
+		controllers.BuyItem(c)
+
+		if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":"Item variant must be specified"}` {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Should take default variant when none is specified", func(t *testing.T) {
+		addedUser := users.AddRow(user.ID, time.Now(), time.Now(), nil, user.Username, user.Password, defaultCoin)
+		addedItem := items.AddRow(item.ID, time.Now(), time.Now(), nil, item.ItemName, item.Price)
+		sizes := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "item_id", "sku", "size", "color", "price", "stock", "is_default"}).
+			AddRow(1, time.Now(), time.Now(), nil, item.ID, "t-shirt-m", "M", "white", nil, 0, true).
+			AddRow(2, time.Now(), time.Now(), nil, item.ID, "t-shirt-l", "L", "white", nil, nil, false)
+
+		checkUserSQL := `SELECT \* FROM "users" WHERE ID = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(user.ID, 1).
+			WillReturnRows(addedUser)
+
+		checkItemSQL := `SELECT \* FROM "items" WHERE item_name = \$1 AND "items"."deleted_at" IS NULL ORDER BY "items"."id" LIMIT \$2`
+		mock.ExpectQuery(checkItemSQL).
+			WithArgs(item.ItemName, 1).
+			WillReturnRows(addedItem)
+
+		//вариант по умолчанию M закончился, L есть, но без параметров выбирается M
+		mock.ExpectQuery(checkVariantsSQL).
+			WithArgs(item.ID).
+			WillReturnRows(sizes)
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodGet, "/", nil)
+		c.Set("user_id", user.ID)
+
+		c.Params = []gin.Param{gin.Param{Key: "item", Value: item.ItemName}}
+
+		controllers.BuyItem(c)
+
+		if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":"Item is sold out"}` {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+
+	})
+
+	t.Run("Should return 400 due to not applicable promo code", func(t *testing.T) {
+		addedUser := users.AddRow(user.ID, time.Now(), time.Now(), nil, user.Username, user.Password, defaultCoin)
+		addedItem := items.AddRow(item.ID, time.Now(), time.Now(), nil, item.ItemName, item.Price)
+		promos := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "code", "kind", "value", "items", "categories"}).
+			AddRow(1, time.Now(), time.Now(), nil, "CUP10", "percent", 10, `["cup"]`, nil)
+
+		checkUserSQL := `SELECT \* FROM "users" WHERE ID = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(user.ID, 1).
+			WillReturnRows(addedUser)
+
+		checkItemSQL := `SELECT \* FROM "items" WHERE item_name = \$1 AND "items"."deleted_at" IS NULL ORDER BY "items"."id" LIMIT \$2`
+		mock.ExpectQuery(checkItemSQL).
+			WithArgs(item.ItemName, 1).
+			WillReturnRows(addedItem)
+		mock.ExpectQuery(checkVariantsSQL).
+			WithArgs(item.ID).
+			WillReturnRows(variants)
+		mock.ExpectQuery(priceRulesSQL).WillReturnRows(priceRules)
+
+		//промокод действует только на кружки, код приводится к верхнему регистру
+		checkPromoSQL := `SELECT \* FROM "promo_codes" WHERE code = \$1 AND "promo_codes"."deleted_at" IS NULL ORDER BY "promo_codes"."id" LIMIT \$2`
+		mock.ExpectQuery(checkPromoSQL).
+			WithArgs("CUP10", 1).
+			WillReturnRows(promos)
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodGet, "/?promo=cup10", nil)
+		c.Set("user_id", user.ID)
+
+		c.Params = []gin.Param{gin.Param{Key: "item", Value: item.ItemName}}
+
+		controllers.BuyItem(c)
+
+		if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":"Promo code is not applicable to this item"}` {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+
+	})
+
+	t.Run("Should buy item at sale price", func(t *testing.T) {
+		addedUser := users.AddRow(user.ID, time.Now(), time.Now(), nil, user.Username, user.Password, defaultCoin)
+		addedItem := items.AddRow(item.ID, time.Now(), time.Now(), nil, item.ItemName, item.Price)
+		sale := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "name", "kind", "value", "items", "categories", "starts_at", "ends_at"}).
+			AddRow(3, time.Now(), time.Now(), nil, "friday", "percent", 25, `["t-shirt"]`, nil, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+		checkUserSQL := `SELECT \* FROM "users" WHERE ID = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(user.ID, 1).
+			WillReturnRows(addedUser)
+
+		checkItemSQL := `SELECT \* FROM "items" WHERE item_name = \$1 AND "items"."deleted_at" IS NULL ORDER BY "items"."id" LIMIT \$2`
+		mock.ExpectQuery(checkItemSQL).
+			WithArgs(item.ItemName, 1).
+			WillReturnRows(addedItem)
+		mock.ExpectQuery(checkVariantsSQL).
+			WithArgs(item.ID).
+			WillReturnRows(variants)
+		mock.ExpectQuery(priceRulesSQL).WillReturnRows(sale)
+
+		//скидка 25% на футболку: 80 -> 60, в покупке сохраняется правило
+		purchaseSQL := `INSERT INTO "purchases" (.+)`
+
+		mock.ExpectBegin()
+		expectDebit(mock, user.ID, 60).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		//выручка магазина зачисляется на служебный счет
+		mock.ExpectExec(shopRevenueSQL).
+			WithArgs(float32(60), sqlmock.AnyArg(), models.ShopAccount, models.RoleSystem).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectChainHead(mock, "purchases")
+		mock.ExpectQuery(purchaseSQL).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, item.ID, user.ID, float32(60), nil, item.Price, float32(20), nil, 3, nil, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(purchases.AddRow(2, time.Now(), time.Now(), nil, item.ID, user.ID, 60))
+		expectOutbox(mock, models.EventPurchaseCreated)
+		expectEvent(mock, models.EventPurchaseCompleted)
 		mock.ExpectCommit()
 
 		gin.SetMode(gin.TestMode)
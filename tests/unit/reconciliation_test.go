@@ -0,0 +1,63 @@
+package unit
+
+import (
+	"avito/database"
+	"avito/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestReconcile(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+
+	database.PostgresDB = db
+	now := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	t.Run("Should report and freeze user whose balance does not match the ledger", func(t *testing.T) {
+		//у bob на 100 монет больше, чем по переводам и покупкам
+		mock.ExpectQuery(`SELECT users.id AS user_id, users.username, users.balance - users.debt AS actual`).
+			WithArgs(models.CategoryWelcome, models.InitialBalance, models.RoleSystem).
+			WillReturnRows(sqlmock.NewRows([]string{"user_id", "username", "actual", "expected"}).
+				AddRow(1, "alice", 920, 920).
+				AddRow(2, "bob", 1100, 1000))
+		mock.ExpectQuery(`SELECT "id" FROM "transactions" WHERE \(sender_id = \$1 OR receiver_id = \$2\) AND \(deleted_at IS NOT NULL OR updated_at <> created_at\) ORDER BY id`).
+			WithArgs(uint(2), uint(2)).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(15))
+		mock.ExpectQuery(`SELECT "id" FROM "purchases" WHERE user_id = \$1`).
+			WithArgs(uint(2)).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT coalesce\(sum\(balance - debt\) filter \(where role <> \$1\), 0\) as circulating`).
+			WithArgs(models.RoleSystem).
+			WillReturnRows(sqlmock.NewRows([]string{"circulating", "imbalance"}).AddRow(2020, 100))
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "users" SET "frozen"=\$1,"updated_at"=\$2 WHERE id IN \(\$3\)`).
+			WithArgs(true, sqlmock.AnyArg(), uint(2)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectQuery(`INSERT INTO "reconciliation_runs" (.+)`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		run, err := models.Reconcile(database.PostgresDB, true, now)
+		assert.NoError(t, err)
+		assert.False(t, run.Consistent())
+		assert.Equal(t, 2, run.Users)
+		assert.Equal(t, []models.BalanceDiscrepancy{{
+			UserID:                 2,
+			Username:               "bob",
+			Expected:               1000,
+			Actual:                 1100,
+			Difference:             100,
+			SuspiciousTransactions: []uint{15},
+			SuspiciousPurchases:    []uint{},
+		}}, run.Discrepancies)
+		assert.Equal(t, []string{"bob"}, run.FrozenUsers)
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+}
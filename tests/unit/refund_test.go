@@ -0,0 +1,171 @@
+package unit
+
+import (
+	"avito/config"
+	"avito/controllers"
+	"avito/database"
+	"avito/models"
+	"bytes"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRefund(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+	var user models.User
+
+	user.ID = 1
+	user.Username = "admin"
+	user.Password = "$2a$14$3S5a3omnocQh0KqgOBjjh.dA/TdNRUnaETsLV5PqjrJ/Gs757i8NS"
+	user.Balance = 920
+
+	database.PostgresDB = db
+	config.Cfg.Refund.Window = 24 * time.Hour
+	userColumns := []string{"id", "created_at", "updated_at", "deleted_at", "username", "password", "balance", "role"}
+	purchaseColumns := []string{"id", "created_at", "updated_at", "deleted_at", "item_id", "user_id", "price", "returned_at"}
+	refundColumns := []string{"id", "created_at", "updated_at", "deleted_at", "purchase_id", "user_id", "status", "reason", "amount"}
+	checkUserSQL := `SELECT \* FROM "users" WHERE ID = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+
+	t.Run("Should not request refund after the window", func(t *testing.T) {
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(user.ID, 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(user.ID, time.Now(), time.Now(), nil, user.Username, user.Password, user.Balance, models.RoleUser))
+
+		//покупка сделана двое суток назад
+		checkPurchaseSQL := `SELECT \* FROM "purchases" WHERE \(id = \$1 AND user_id = \$2\) AND "purchases"."deleted_at" IS NULL ORDER BY "purchases"."id" LIMIT \$3`
+		mock.ExpectQuery(checkPurchaseSQL).
+			WithArgs("7", user.ID, 1).
+			WillReturnRows(sqlmock.NewRows(purchaseColumns).
+				AddRow(7, time.Now().Add(-48*time.Hour), time.Now(), nil, 1, user.ID, 80, nil))
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(`{"reason":"too small"}`)))
+		c.Set("user_id", user.ID)
+		c.Params = []gin.Param{{Key: "id", Value: "7"}}
+
+		controllers.RequestRefund(c)
+
+		if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":"Refund window for this purchase is closed"}` {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Should approve refund and credit coins back", func(t *testing.T) {
+		adminID := uint(2)
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(adminID, 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(adminID, time.Now(), time.Now(), nil, "boss", user.Password, 1000, models.RoleAdmin))
+
+		mock.ExpectBegin()
+		lockRefundSQL := `SELECT \* FROM "refunds" WHERE id = \$1 (.+) FOR UPDATE`
+		mock.ExpectQuery(lockRefundSQL).
+			WithArgs("3", 1).
+			WillReturnRows(sqlmock.NewRows(refundColumns).
+				AddRow(3, time.Now(), time.Now(), nil, 7, user.ID, models.RefundPending, "too small", 80))
+		mock.ExpectExec(`UPDATE "refunds" SET "updated_at"=\$1,"status"=\$2,"reviewer_id"=\$3,"reviewed_at"=\$4,"comment"=\$5`).
+			WithArgs(sqlmock.AnyArg(), models.RefundApproved, adminID, sqlmock.AnyArg(), "", 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT \* FROM "purchases" WHERE id = \$1`).
+			WithArgs(uint(7), 1).
+			WillReturnRows(sqlmock.NewRows(purchaseColumns).
+				AddRow(7, time.Now(), time.Now(), nil, 1, user.ID, 80, nil))
+		mock.ExpectExec(`UPDATE "purchases" SET "returned_at"=\$1`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		//монеты возвращаются, остаток товара восстанавливается
+		mock.ExpectExec(`UPDATE "users" SET "balance"=balance \+ GREATEST\(\$1 - debt, 0\),"debt"=GREATEST\(debt - \$2, 0\),"updated_at"=\$3 WHERE id = \$4`).
+			WithArgs(float32(80), float32(80), sqlmock.AnyArg(), user.ID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE "users" SET "balance"=balance \+ \$1,"updated_at"=\$2 WHERE \(username = \$3 AND role = \$4\)`).
+			WithArgs(float32(-80), sqlmock.AnyArg(), models.ShopAccount, models.RoleSystem).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectEvent(mock, models.EventCoinReceived)
+		mock.ExpectExec(`UPDATE "items" SET "stock"=stock \+ 1 WHERE \(id = \$1 AND stock IS NOT NULL\)`).
+			WithArgs(uint(1)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		//решение попадает в аудит в той же транзакции
+		mock.ExpectQuery(`INSERT INTO "audit_logs"`).
+			WithArgs(sqlmock.AnyArg(), adminID, "", "refund approved", "refund=3", "", sqlmock.AnyArg(),
+				models.AuditSuccess, http.StatusOK, "").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodPost, "/", nil)
+		c.Set("user_id", adminID)
+		c.Params = []gin.Param{{Key: "id", Value: "3"}}
+
+		controllers.ApproveRefund(c)
+
+		if w.Code != http.StatusOK {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Should reject refund and audit the decision", func(t *testing.T) {
+		adminID := uint(2)
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(adminID, 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(adminID, time.Now(), time.Now(), nil, "boss", user.Password, 1000, models.RoleAdmin))
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "refunds" WHERE id = \$1 (.+) FOR UPDATE`).
+			WithArgs("3", 1).
+			WillReturnRows(sqlmock.NewRows(refundColumns).
+				AddRow(3, time.Now(), time.Now(), nil, 7, user.ID, models.RefundPending, "too small", 80))
+		mock.ExpectExec(`UPDATE "refunds" SET "updated_at"=\$1,"status"=\$2,"reviewer_id"=\$3,"reviewed_at"=\$4,"comment"=\$5`).
+			WithArgs(sqlmock.AnyArg(), models.RefundRejected, adminID, sqlmock.AnyArg(), "used", 3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO "audit_logs"`).
+			WithArgs(sqlmock.AnyArg(), adminID, "", "refund rejected", "refund=3", "", sqlmock.AnyArg(),
+				models.AuditSuccess, http.StatusOK, "used").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectCommit()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(`{"comment":"used"}`)))
+		c.Set("user_id", adminID)
+		c.Params = []gin.Param{{Key: "id", Value: "3"}}
+
+		controllers.RejectRefund(c)
+
+		if w.Code != http.StatusOK {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package unit
+
+import (
+	"avito/database"
+	"avito/models"
+	"avito/relay"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func outboxEvents() []models.OutboxEvent {
+	return []models.OutboxEvent{
+		{ID: 1, Type: models.EventTransactionCreated, UserID: 3, Data: map[string]interface{}{"amount": 10}},
+		{ID: 2, Type: models.EventPurchaseCreated, UserID: 4, Data: map[string]interface{}{"price": 20}},
+	}
+}
+
+func TestRelaySinks(t *testing.T) {
+	t.Run("Сообщения брокера с ключом пользователя", func(t *testing.T) {
+		broker := relay.NewMemoryBroker()
+		sink := relay.BrokerSink{Broker: broker, Topic: "shop-events"}
+
+		assert.NoError(t, sink.Publish(context.Background(), outboxEvents()))
+
+		messages := broker.Messages("shop-events")
+		assert.Len(t, messages, 2)
+		assert.Equal(t, "3", string(messages[0].Key))
+		assert.Equal(t, models.EventTransactionCreated, messages[0].Headers["event_type"])
+		assert.Equal(t, "2", messages[1].Headers["event_id"])
+		var event models.OutboxEvent
+		assert.NoError(t, json.Unmarshal(messages[1].Value, &event))
+		assert.Equal(t, models.EventPurchaseCreated, event.Type)
+	})
+
+	t.Run("Файл из JSON-строк дописывается", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.jsonl")
+		for i := 0; i < 2; i++ {
+			sink, err := relay.NewFileSink(path)
+			assert.NoError(t, err)
+			assert.NoError(t, sink.Publish(context.Background(), outboxEvents()))
+			assert.NoError(t, sink.Close())
+		}
+
+		file, err := os.Open(path)
+		assert.NoError(t, err)
+		defer file.Close()
+		lines := 0
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var event models.OutboxEvent
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+			lines++
+		}
+		assert.Equal(t, 4, lines)
+	})
+}
+
+func TestRelayEvents(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+	database.PostgresDB = db
+	now := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	pendingSQL := `SELECT \* FROM "outbox_events" WHERE relayed_at IS NULL AND id <= \$1 ORDER BY id LIMIT \$2$`
+	pendingRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "type", "user_id", "data"}).
+			AddRow(1, models.EventTransactionCreated, 3, `{"amount":10}`).
+			AddRow(2, models.EventPurchaseCreated, 4, `{"price":20}`)
+	}
+	claimSQL := `INSERT INTO "relay_leases" \("name","holder","expires_at"\) VALUES \(\$1,\$2,\$3\) ` +
+		`ON CONFLICT \("name"\) DO UPDATE SET "holder"="excluded"."holder","expires_at"="excluded"."expires_at" ` +
+		`WHERE relay_leases.expires_at <= \$4`
+	releaseSQL := `DELETE FROM "relay_leases" WHERE name = \$1 AND holder = \$2`
+	expectClaim := func(claimed int64) {
+		mock.ExpectBegin()
+		mock.ExpectExec(claimSQL).
+			WithArgs("outbox", sqlmock.AnyArg(), now.Add(90*time.Second), now).
+			WillReturnResult(sqlmock.NewResult(0, claimed))
+		mock.ExpectCommit()
+	}
+	//занять аренду, дождаться открытых транзакций, пишущих события, и взять последний id
+	expectWatermark := func(watermark int) {
+		expectClaim(1)
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT pg_advisory_xact_lock\(\$1\)`).
+			WithArgs(4403).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT coalesce\(max\(id\), 0\) FROM "outbox_events"`).
+			WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(watermark))
+		mock.ExpectCommit()
+	}
+
+	t.Run("События публикуются и отмечаются", func(t *testing.T) {
+		broker := relay.NewMemoryBroker()
+		expectWatermark(2)
+		mock.ExpectQuery(pendingSQL).WithArgs(2, 100).WillReturnRows(pendingRows())
+		//публикация идет без транзакции, отметка и снятие аренды — короткой
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "outbox_events" SET "relayed_at"=\$1 WHERE id IN \(\$2,\$3\)`).
+			WithArgs(now, 1, 2).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(releaseSQL).WithArgs("outbox", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		count, err := relay.RunDue(context.Background(), relay.BrokerSink{Broker: broker, Topic: "events"}, now)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+		assert.Len(t, broker.Messages("events"), 2)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Ошибка брокера оставляет события в outbox", func(t *testing.T) {
+		broker := relay.NewMemoryBroker()
+		broker.Fail = errors.New("broker is down")
+		expectWatermark(2)
+		mock.ExpectQuery(pendingSQL).WithArgs(2, 100).WillReturnRows(pendingRows())
+		mock.ExpectBegin()
+		mock.ExpectExec(releaseSQL).WithArgs("outbox", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		count, err := relay.RunDue(context.Background(), relay.BrokerSink{Broker: broker, Topic: "events"}, now)
+		assert.Error(t, err)
+		assert.Equal(t, 0, count)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("События после незавершенной записи ждут следующего запуска", func(t *testing.T) {
+		broker := relay.NewMemoryBroker()
+		//событие 3 уже видно, но 2 еще не закоммичено: граница остается на 1
+		expectWatermark(1)
+		mock.ExpectQuery(pendingSQL).WithArgs(1, 100).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "type", "user_id", "data"}).
+				AddRow(1, models.EventTransactionCreated, 3, `{"amount":10}`))
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "outbox_events" SET "relayed_at"=\$1 WHERE id IN \(\$2\)`).
+			WithArgs(now, 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(releaseSQL).WithArgs("outbox", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		count, err := relay.RunDue(context.Background(), relay.BrokerSink{Broker: broker, Topic: "events"}, now)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+		assert.Len(t, broker.Messages("events"), 1)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+	t.Run("Пока аренда у другого ретранслятора, события не публикуются", func(t *testing.T) {
+		broker := relay.NewMemoryBroker()
+		expectClaim(0)
+
+		count, err := relay.RunDue(context.Background(), relay.BrokerSink{Broker: broker, Topic: "events"}, now)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count)
+		assert.Empty(t, broker.Messages("events"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,158 @@
+package unit
+
+import (
+	"avito/controllers"
+	"avito/database"
+	"avito/models"
+	"bytes"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReverseTransaction(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+
+	database.PostgresDB = db
+	userColumns := []string{"id", "created_at", "updated_at", "deleted_at", "username", "password", "balance", "role", "debt"}
+	transactionColumns := []string{"id", "created_at", "updated_at", "deleted_at", "sender_id", "receiver_id", "amount", "reversal_of_id"}
+	checkUserSQL := `SELECT \* FROM "users" WHERE ID = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+	lockTransactionSQL := `SELECT \* FROM "transactions" WHERE id = \$1 AND "transactions"."deleted_at" IS NULL ORDER BY "transactions"."id" LIMIT \$2 FOR UPDATE`
+	countReversalsSQL := `SELECT count\(\*\) FROM "transactions" WHERE reversal_of_id = \$1`
+	lockUsersSQL := `SELECT \* FROM "users" WHERE id IN \(\$1,\$2\) AND "users"."deleted_at" IS NULL ORDER BY id FOR UPDATE`
+
+	t.Run("Should not reverse transaction twice", func(t *testing.T) {
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(uint(1), 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(1, time.Now(), time.Now(), nil, "admin", "", 1000, models.RoleAdmin, 0))
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockTransactionSQL).
+			WithArgs(uint(5), 1).
+			WillReturnRows(sqlmock.NewRows(transactionColumns).
+				AddRow(5, time.Now(), time.Now(), nil, 2, 3, 100, nil))
+		mock.ExpectQuery(countReversalsSQL).
+			WithArgs(uint(5)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(`{"reason":"fraud"}`)))
+		c.Set("user_id", uint(1))
+		c.Params = []gin.Param{{Key: "id", Value: "5"}}
+
+		controllers.ReverseTransaction(c)
+
+		if w.Code != http.StatusConflict || w.Body.String() != `{"error":"Transaction is already reversed"}` {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Should not reverse when receiver spent the coins", func(t *testing.T) {
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(uint(1), 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(1, time.Now(), time.Now(), nil, "admin", "", 1000, models.RoleAdmin, 0))
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockTransactionSQL).
+			WithArgs(uint(5), 1).
+			WillReturnRows(sqlmock.NewRows(transactionColumns).
+				AddRow(5, time.Now(), time.Now(), nil, 2, 3, 100, nil))
+		mock.ExpectQuery(countReversalsSQL).
+			WithArgs(uint(5)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+		//у получателя осталось меньше, чем он получил
+		mock.ExpectQuery(lockUsersSQL).
+			WithArgs(uint(2), uint(3)).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(2, time.Now(), time.Now(), nil, "sender", "", 900, models.RoleUser, 0).
+				AddRow(3, time.Now(), time.Now(), nil, "receiver", "", 40, models.RoleUser, 0))
+		mock.ExpectRollback()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(`{"reason":"fraud"}`)))
+		c.Set("user_id", uint(1))
+		c.Params = []gin.Param{{Key: "id", Value: "5"}}
+
+		controllers.ReverseTransaction(c)
+
+		if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":"Receiver does not have enough coins for reversal"}` {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Should reverse transaction and notify both users", func(t *testing.T) {
+		mock.ExpectQuery(checkUserSQL).
+			WithArgs(uint(1), 1).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(1, time.Now(), time.Now(), nil, "admin", "", 1000, models.RoleAdmin, 0))
+		mock.ExpectBegin()
+		mock.ExpectQuery(lockTransactionSQL).
+			WithArgs(uint(5), 1).
+			WillReturnRows(sqlmock.NewRows(transactionColumns).
+				AddRow(5, time.Now(), time.Now(), nil, 2, 3, 100, nil))
+		mock.ExpectQuery(countReversalsSQL).
+			WithArgs(uint(5)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery(lockUsersSQL).
+			WithArgs(uint(2), uint(3)).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(2, time.Now(), time.Now(), nil, "sender", "", 900, models.RoleUser, 0).
+				AddRow(3, time.Now(), time.Now(), nil, "receiver", "", 400, models.RoleUser, 0))
+		mock.ExpectExec(`UPDATE "users" SET "balance"=balance - \$1,"debt"=debt \+ \$2,"updated_at"=\$3 WHERE id = \$4`).
+			WithArgs(float32(100), float32(0), sqlmock.AnyArg(), uint(3)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE "users" SET "balance"=balance \+ GREATEST\(\$1 - debt, 0\),"debt"=GREATEST\(debt - \$2, 0\),"updated_at"=\$3 WHERE id = \$4`).
+			WithArgs(float32(100), float32(100), sqlmock.AnyArg(), uint(2)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectChainHead(mock, "transactions")
+		mock.ExpectQuery(`INSERT INTO "transactions"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(6))
+		expectOutbox(mock, models.EventTransactionCreated)
+		//монеты списаны у получателя и вернулись отправителю
+		expectEvent(mock, models.EventCoinReceived)
+		expectEvent(mock, models.EventCoinSent)
+		mock.ExpectCommit()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+
+		c.Request, _ = http.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(`{"reason":"fraud"}`)))
+		c.Set("user_id", uint(1))
+		c.Params = []gin.Param{{Key: "id", Value: "5"}}
+
+		controllers.ReverseTransaction(c)
+
+		if w.Code != http.StatusOK {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package unit
+
+import (
+	"avito/database"
+	"avito/models"
+	"avito/scheduler"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNextSlot(t *testing.T) {
+	monday := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	//следующий понедельник
+	next, ok := models.NextSlot(models.ScheduleWeekly, monday, monday)
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2025, 3, 10, 10, 0, 0, 0, time.UTC), next)
+
+	//пропущенные слоты не наверстываются
+	next, ok = models.NextSlot(models.ScheduleWeekly, monday, monday.AddDate(0, 0, 20))
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2025, 3, 24, 10, 0, 0, 0, time.UTC), next)
+
+	_, ok = models.NextSlot(models.ScheduleOnce, monday, monday)
+	assert.False(t, ok)
+}
+
+func TestRunScheduledTransfers(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+
+	database.PostgresDB = db
+	userColumns := []string{"id", "created_at", "updated_at", "deleted_at", "username", "password", "balance", "role", "debt"}
+	scheduleColumns := []string{"id", "created_at", "updated_at", "deleted_at", "sender_id", "receiver_id", "amount", "memo", "category", "interval", "status", "next_run_at", "failures"}
+	dueSQL := `SELECT \* FROM "scheduled_transfers" WHERE \(status = \$1 AND next_run_at <= \$2 AND \(retry_at IS NULL OR retry_at <= \$3\)\) AND "scheduled_transfers"."deleted_at" IS NULL ORDER BY next_run_at LIMIT \$4 FOR UPDATE SKIP LOCKED`
+	updateScheduleSQL := `UPDATE "scheduled_transfers" SET "updated_at"=\$1,"status"=\$2,"next_run_at"=\$3,"last_run_at"=\$4,"last_error"=\$5,"failures"=\$6,"retry_at"=\$7`
+	slot := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	now := slot.Add(time.Minute)
+
+	t.Run("Should skip run without enough coins and move to next slot", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(dueSQL).
+			WithArgs(models.ScheduledTransferActive, now, now, 1).
+			WillReturnRows(sqlmock.NewRows(scheduleColumns).
+				AddRow(4, time.Now(), time.Now(), nil, 1, 2, 10, "mentor", models.CategoryThanks, models.ScheduleWeekly, models.ScheduledTransferActive, slot, 0))
+		mock.ExpectQuery(`INSERT INTO "scheduled_transfer_runs" (.+) ON CONFLICT DO NOTHING RETURNING "id"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(`SELECT \* FROM "users" WHERE id IN \(\$1,\$2\) AND "users"."deleted_at" IS NULL ORDER BY id FOR UPDATE`).
+			WithArgs(uint(1), uint(2)).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(1, time.Now(), time.Now(), nil, "mentee", "", 5, models.RoleUser, 0).
+				AddRow(2, time.Now(), time.Now(), nil, "mentor", "", 1000, models.RoleUser, 0))
+
+		//перевода нет, причина записывается в запуск
+		mock.ExpectExec(`UPDATE "scheduled_transfer_runs" SET "updated_at"=\$1,"status"=\$2,"reason"=\$3,"transaction_id"=\$4`).
+			WithArgs(sqlmock.AnyArg(), models.ScheduledRunSkipped, models.ErrInsufficientFunds.Error(), nil, uint(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(updateScheduleSQL).
+			WithArgs(sqlmock.AnyArg(), models.ScheduledTransferActive, slot.AddDate(0, 0, 7), now, models.ErrInsufficientFunds.Error(), 0, nil, uint(4)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		//больше ничего не запланировано
+		mock.ExpectBegin()
+		mock.ExpectQuery(dueSQL).
+			WithArgs(models.ScheduledTransferActive, now, now, 1).
+			WillReturnRows(sqlmock.NewRows(scheduleColumns))
+		mock.ExpectCommit()
+
+		count, err := scheduler.RunDue(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Should not repeat already executed slot", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(dueSQL).
+			WithArgs(models.ScheduledTransferActive, now, now, 1).
+			WillReturnRows(sqlmock.NewRows(scheduleColumns).
+				AddRow(4, time.Now(), time.Now(), nil, 1, 2, 10, "mentor", models.CategoryThanks, models.ScheduleOnce, models.ScheduledTransferActive, slot, 0))
+
+		//слот уже есть в scheduled_transfer_runs
+		mock.ExpectQuery(`INSERT INTO "scheduled_transfer_runs" (.+) ON CONFLICT DO NOTHING RETURNING "id"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(updateScheduleSQL).
+			WithArgs(sqlmock.AnyArg(), models.ScheduledTransferCompleted, slot, nil, "", 0, nil, uint(4)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectQuery(dueSQL).
+			WithArgs(models.ScheduledTransferActive, now, now, 1).
+			WillReturnRows(sqlmock.NewRows(scheduleColumns))
+		mock.ExpectCommit()
+
+		count, err := scheduler.RunDue(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+	t.Run("Should postpone broken schedule and run the next one", func(t *testing.T) {
+		lockUsersSQL := `SELECT \* FROM "users" WHERE id IN \(\$1,\$2\) AND "users"."deleted_at" IS NULL ORDER BY id FOR UPDATE`
+		mock.ExpectBegin()
+		mock.ExpectQuery(dueSQL).
+			WithArgs(models.ScheduledTransferActive, now, now, 1).
+			WillReturnRows(sqlmock.NewRows(scheduleColumns).
+				AddRow(4, time.Now(), time.Now(), nil, 1, 2, 10, "mentor", models.CategoryThanks, models.ScheduleWeekly, models.ScheduledTransferActive, slot, 2))
+		mock.ExpectQuery(`INSERT INTO "scheduled_transfer_runs" (.+) ON CONFLICT DO NOTHING RETURNING "id"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery(lockUsersSQL).
+			WithArgs(uint(1), uint(2)).
+			WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+
+		//ошибка записывается, слот повторяется через 4 минуты (третья неудача подряд)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "scheduled_transfers" SET "failures"=\$1,"last_error"=\$2,"retry_at"=\$3,"updated_at"=\$4 WHERE id = \$5`).
+			WithArgs(3, sql.ErrConnDone.Error(), now.Add(4*time.Minute), sqlmock.AnyArg(), uint(4)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		//следующее по очереди расписание выполняется
+		mock.ExpectBegin()
+		mock.ExpectQuery(dueSQL).
+			WithArgs(models.ScheduledTransferActive, now, now, 1).
+			WillReturnRows(sqlmock.NewRows(scheduleColumns).
+				AddRow(5, time.Now(), time.Now(), nil, 3, 2, 10, "lunch", models.CategoryPayback, models.ScheduleOnce, models.ScheduledTransferActive, slot, 0))
+		mock.ExpectQuery(`INSERT INTO "scheduled_transfer_runs" (.+) ON CONFLICT DO NOTHING RETURNING "id"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		mock.ExpectQuery(lockUsersSQL).
+			WithArgs(uint(3), uint(2)).
+			WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(2, time.Now(), time.Now(), nil, "mentor", "", 1000, models.RoleUser, 0).
+				AddRow(3, time.Now(), time.Now(), nil, "friend", "", 5, models.RoleUser, 0))
+		mock.ExpectExec(`UPDATE "scheduled_transfer_runs" SET "updated_at"=\$1,"status"=\$2,"reason"=\$3,"transaction_id"=\$4`).
+			WithArgs(sqlmock.AnyArg(), models.ScheduledRunSkipped, models.ErrInsufficientFunds.Error(), nil, uint(2)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(updateScheduleSQL).
+			WithArgs(sqlmock.AnyArg(), models.ScheduledTransferCompleted, slot, now, models.ErrInsufficientFunds.Error(), 0, nil, uint(5)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		//сломанное расписание до retry_at не выбирается
+		mock.ExpectBegin()
+		mock.ExpectQuery(dueSQL).
+			WithArgs(models.ScheduledTransferActive, now, now, 1).
+			WillReturnRows(sqlmock.NewRows(scheduleColumns))
+		mock.ExpectCommit()
+
+		count, err := scheduler.RunDue(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+	})
+}
@@ -17,6 +17,12 @@ import (
 	"time"
 )
 
+// expectDebit ожидает списание, которое проверяет баланс в том же запросе
+func expectDebit(mock sqlmock.Sqlmock, userID uint, amount float32) *sqlmock.ExpectedExec {
+	debitSQL := `UPDATE "users" SET "balance"=balance - \$1,"updated_at"=\$2 WHERE \(id = \$3 AND balance >= \$4\) AND "users"."deleted_at" IS NULL`
+	return mock.ExpectExec(debitSQL).WithArgs(amount, sqlmock.AnyArg(), userID, amount)
+}
+
 func TestSendCoin(t *testing.T) {
 	sqlDB, db, mock := DbMock(t)
 	defer sqlDB.Close()
@@ -38,6 +44,14 @@ func TestSendCoin(t *testing.T) {
 	database.PostgresDB = db
 	users := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "username", "password", "balance"})
 	transactions := sqlmock.NewRows([]string{"id", "created_at", "updated_at", "deleted_at", "sender_id", "receiver_id", "amount"})
+	creditSQL := `UPDATE "users" SET "balance"=balance \+ GREATEST\(\$1 - debt, 0\),"debt"=GREATEST\(debt - \$2, 0\),"updated_at"=\$3 WHERE id = \$4`
+
+	//оба пользователя блокируются до списания и до блокировки цепочки
+	expectLockUsers := func() {
+		mock.ExpectQuery(`SELECT \* FROM "users" WHERE id IN \(\$1,\$2\) AND "users"."deleted_at" IS NULL ORDER BY id FOR UPDATE`).
+			WithArgs(sender.ID, receiver.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(sender.ID).AddRow(receiver.ID))
+	}
 
 	t.Run("Should not bind sendCoin schema StatusBadRequest", func(t *testing.T) {
 		sendCoinBody := map[string]interface{}{
@@ -64,6 +78,33 @@ func TestSendCoin(t *testing.T) {
 
 	})
 
+	t.Run("Should not bind unknown transfer category StatusBadRequest", func(t *testing.T) {
+		sendCoinBody := map[string]interface{}{
+			"ToUser":   "receiver",
+			"amount":   10,
+			"category": "bribe"}
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		authBody, err := json.Marshal(sendCoinBody)
+		assert.NoError(t, err)
+
+		c.Request, _ = http.NewRequest(http.MethodPost, "/", bytes.NewReader(authBody))
+
+		controllers.SendCoin(c)
+
+		if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":"Key: 'SendToPayload.Category' Error:Field validation for 'Category' failed on the 'oneof' tag"}` {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+
+	})
+
 	t.Run("Should not authorize due to non-existent user", func(t *testing.T) {
 		sendCoinBody := map[string]interface{}{
 			"ToUser": "someUser",
@@ -119,9 +160,13 @@ func TestSendCoin(t *testing.T) {
 			WillReturnRows(receiverAdded)
 
 		//Транзакция: не пройдет так как amount < 0
-		createTransactionSQL := `INSERT INTO "transactions" \("created_at","updated_at","deleted_at","sender_id","receiver_id","amount"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6\) (.+)`
+		createTransactionSQL := `INSERT INTO "transactions" \("created_at","updated_at","deleted_at","sender_id","receiver_id","amount","reversal_of_id","reversed_by_id","reason","memo","category","prev_hash","hash"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6,\$7,\$8,\$9,\$10,\$11,\$12,\$13\) (.+)`
 
 		mock.ExpectBegin()
+		expectLockUsers()
+		expectDebit(mock, sender.ID, -100).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(creditSQL).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectChainHead(mock, "transactions")
 		mock.ExpectQuery(createTransactionSQL).WillReturnError(gorm.ErrCheckConstraintViolated)
 
 		gin.SetMode(gin.TestMode)
@@ -230,6 +275,51 @@ func TestSendCoin(t *testing.T) {
 
 	})
 
+	t.Run("Could not send coins spent by a concurrent transfer", func(t *testing.T) {
+		sendCoinBody := map[string]interface{}{
+			"ToUser": "receiver",
+			"amount": 300}
+
+		//загруженный sender еще видит 1000 монет, но они уже потрачены
+		senderAdded := users.AddRow(sender.ID, time.Now(), time.Now(), nil, sender.Username, sender.Password, defaultCoin)
+		receiverAdded := users.AddRow(receiver.ID, time.Now(), time.Now(), nil, receiver.Username, receiver.Password, defaultCoin)
+
+		checkSenderSQL := `SELECT \* FROM "users" WHERE ID = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+		mock.ExpectQuery(checkSenderSQL).
+			WithArgs(sender.ID, 1).
+			WillReturnRows(senderAdded)
+		checkReceiverSQL := `SELECT \* FROM "users" WHERE Username = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+		mock.ExpectQuery(checkReceiverSQL).
+			WithArgs(receiver.Username, 1).
+			WillReturnRows(receiverAdded)
+
+		mock.ExpectBegin()
+		expectLockUsers()
+		expectDebit(mock, sender.ID, 300).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		gin.SetMode(gin.TestMode)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		authBody, err := json.Marshal(sendCoinBody)
+		assert.NoError(t, err)
+
+		c.Request, _ = http.NewRequest(http.MethodPost, "/", bytes.NewReader(authBody))
+		c.Set("user_id", sender.ID)
+
+		controllers.SendCoin(c)
+
+		if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":"Insufficient funds to complete the transaction"}` {
+			b, _ := ioutil.ReadAll(w.Body)
+			t.Error(w.Code, string(b))
+		}
+		if err = mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("There were unfulfilled expectations: %s", err)
+		}
+
+	})
+
 	t.Run("Could not send coins due to broken transaction", func(t *testing.T) {
 		sendCoinBody := map[string]interface{}{
 			"ToUser": "receiver",
@@ -250,15 +340,11 @@ func TestSendCoin(t *testing.T) {
 			WithArgs(receiver.Username, 1).
 			WillReturnRows(receiverAdded)
 
-		//Транзакция: добавить transaction, обновить баланс у отправителя и получателя, обновить баланс не получилось
-		createTransactionSQL := `INSERT INTO "transactions" \("created_at","updated_at","deleted_at","sender_id","receiver_id","amount"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6\) (.+)`
-		updateBalanceSQL := `UPDATE "users" SET "balance"=\$1,"updated_at"=\$2 WHERE "users"."deleted_at" IS NULL AND "id" = \$3`
-
-		addedTransaction := transactions.AddRow(1, time.Now(), time.Now(), nil, sender.ID, receiver.ID, sendCoinBody["amount"])
-
+		//Транзакция: списать баланс у отправителя не получилось
 		mock.ExpectBegin()
-		mock.ExpectQuery(createTransactionSQL).WillReturnRows(addedTransaction)
-		mock.ExpectExec(updateBalanceSQL).WillReturnError(gorm.ErrInvalidTransaction)
+		expectLockUsers()
+		expectDebit(mock, sender.ID, 300).WillReturnError(gorm.ErrInvalidTransaction)
+		mock.ExpectRollback()
 
 		gin.SetMode(gin.TestMode)
 
@@ -284,8 +370,10 @@ func TestSendCoin(t *testing.T) {
 
 	t.Run("Should add new transaction OK status", func(t *testing.T) {
 		sendCoinBody := map[string]interface{}{
-			"ToUser": "receiver",
-			"amount": 1000}
+			"ToUser":   "receiver",
+			"amount":   1000,
+			"memo":     "  for\tlunch\u0000\n ",
+			"category": "payback"}
 
 		senderAdded := users.AddRow(sender.ID, time.Now(), time.Now(), nil, sender.Username, sender.Password, defaultCoin)
 		receiverAdded := users.AddRow(receiver.ID, time.Now(), time.Now(), nil, receiver.Username, receiver.Password, defaultCoin)
@@ -302,16 +390,25 @@ func TestSendCoin(t *testing.T) {
 			WithArgs(receiver.Username, 1).
 			WillReturnRows(receiverAdded)
 
-		//Транзакция: добавить transaction, обновить баланс у отправителя и получателя
-		createTransactionSQL := `INSERT INTO "transactions" \("created_at","updated_at","deleted_at","sender_id","receiver_id","amount"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6\) (.+)`
-		updateBalanceSQL := `UPDATE "users" SET "balance"=\$1,"updated_at"=\$2 WHERE "users"."deleted_at" IS NULL AND "id" = \$3`
+		//Транзакция: обновить баланс у отправителя и получателя, затем добавить transaction
+		createTransactionSQL := `INSERT INTO "transactions" \("created_at","updated_at","deleted_at","sender_id","receiver_id","amount","reversal_of_id","reversed_by_id","reason","memo","category","prev_hash","hash"\) VALUES \(\$1,\$2,\$3,\$4,\$5,\$6,\$7,\$8,\$9,\$10,\$11,\$12,\$13\) (.+)`
 
 		addedTransaction := transactions.AddRow(1, time.Now(), time.Now(), nil, sender.ID, receiver.ID, sendCoinBody["amount"])
 
 		mock.ExpectBegin()
-		mock.ExpectQuery(createTransactionSQL).WillReturnRows(addedTransaction)
-		mock.ExpectExec(updateBalanceSQL).WillReturnResult(sqlmock.NewResult(0, 1))
-		mock.ExpectExec(updateBalanceSQL).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectLockUsers()
+		expectDebit(mock, sender.ID, 1000).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(creditSQL).
+			WithArgs(float32(1000), float32(1000), sqlmock.AnyArg(), receiver.ID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		//memo очищается от управляющих символов
+		expectChainHead(mock, "transactions")
+		mock.ExpectQuery(createTransactionSQL).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, sender.ID, receiver.ID, float32(1000), nil, nil, "", "for lunch", "payback", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(addedTransaction)
+		expectOutbox(mock, models.EventTransactionCreated)
+		expectEvent(mock, models.EventCoinSent)
+		expectEvent(mock, models.EventCoinReceived)
 		mock.ExpectCommit()
 
 		gin.SetMode(gin.TestMode)
@@ -0,0 +1,119 @@
+package unit
+
+import (
+	"avito/controllers"
+	"avito/database"
+	"avito/models"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetStatement(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+	database.PostgresDB = db
+	gin.SetMode(gin.TestMode)
+
+	userSQL := `SELECT \* FROM "users" WHERE ID = \$1 AND "users"."deleted_at" IS NULL ORDER BY "users"."id" LIMIT \$2`
+	openingSQL := `SELECT coalesce\(sum\(amount\), 0\) FROM \((.+)\) entries WHERE at < \$`
+	entriesSQL := `SELECT \* FROM \((.+)\) entries WHERE at >= \$(.+) AND at < \$(.+) ORDER BY at, reference`
+	day := time.Date(2025, 3, 5, 12, 0, 0, 0, time.UTC)
+
+	expectStatement := func() {
+		mock.ExpectQuery(userSQL).WithArgs(uint(1), 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "balance"}).AddRow(1, "alice", 1150))
+		//начальный баланс и записи читаются из одного снимка
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT set_config\('statement_timeout', \$1, true\)`).WithArgs("300000").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(openingSQL).WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(1000))
+		mock.ExpectQuery(entriesSQL).WillReturnRows(
+			sqlmock.NewRows([]string{"at", "kind", "counterparty", "amount", "memo", "category", "reference"}).
+				AddRow(day, models.EntryReceived, "bob", 200, "за пиццу", models.CategoryPayback, 7).
+				AddRow(day.Add(time.Hour), models.EntryPurchase, "cup", -50, "", "", 3))
+		mock.ExpectCommit()
+	}
+	request := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodGet, "/api/statement?"+query, nil)
+		c.Set("user_id", uint(1))
+		controllers.GetStatement(c)
+		return w
+	}
+
+	t.Run("Выписка в CSV с начальным, текущим и конечным балансом", func(t *testing.T) {
+		expectStatement()
+
+		w := request("from=2025-03-01&to=2025-03-31&format=csv")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Disposition"), "statement-2025-03-01-2025-03-31.csv")
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		assert.Equal(t, []string{
+			"at,kind,counterparty,amount,balance,memo,category,reference",
+			"2025-03-01T00:00:00Z,opening,,,1000.00,,,",
+			"2025-03-05T12:00:00Z,received,bob,200.00,1200.00,за пиццу,payback,7",
+			"2025-03-05T13:00:00Z,purchase,cup,-50.00,1150.00,,,3",
+			"2025-04-01T00:00:00Z,closing,,,1150.00,,,",
+		}, lines)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Формулы в тексте CSV не исполняются", func(t *testing.T) {
+		mock.ExpectQuery(userSQL).WithArgs(uint(1), 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "balance"}).AddRow(1, "alice", 1200))
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT set_config`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(openingSQL).WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(1000))
+		mock.ExpectQuery(entriesSQL).WillReturnRows(
+			sqlmock.NewRows([]string{"at", "kind", "counterparty", "amount", "memo", "category", "reference"}).
+				AddRow(day, models.EntryReceived, "@bob", 200, `=HYPERLINK("http://evil")`, "", 7))
+		mock.ExpectCommit()
+
+		w := request("from=2025-03-01&to=2025-03-31&format=csv")
+
+		assert.Contains(t, w.Body.String(), `received,'@bob,200.00,1200.00,"'=HYPERLINK(""http://evil"")",,7`)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Выписка в JSON", func(t *testing.T) {
+		var statement struct {
+			Username string                  `json:"username"`
+			Opening  float32                 `json:"opening_balance"`
+			Entries  []models.StatementEntry `json:"entries"`
+			Closing  float32                 `json:"closing_balance"`
+		}
+		expectStatement()
+
+		w := request("from=2025-03-01&to=2025-03-31")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &statement))
+		assert.Equal(t, "alice", statement.Username)
+		assert.Equal(t, float32(1000), statement.Opening)
+		assert.Len(t, statement.Entries, 2)
+		assert.Equal(t, float32(1200), statement.Entries[0].Balance)
+		assert.Equal(t, float32(1150), statement.Closing)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Неизвестный формат и перевернутый период", func(t *testing.T) {
+		for _, query := range []string{"format=xml", "from=2025-03-10&to=2025-03-01"} {
+			mock.ExpectQuery(userSQL).WithArgs(uint(1), 1).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "alice"))
+
+			w := request(query)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,148 @@
+package unit
+
+import (
+	"avito/database"
+	"avito/models"
+	"avito/webhooks"
+	"encoding/json"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func webhookDelivery(url string) models.WebhookDelivery {
+	delivery := models.WebhookDelivery{ID: 11, SubscriptionID: 2, EventID: 5, Status: models.DeliveryPending}
+	delivery.Subscription = models.WebhookSubscription{ID: 2, URL: url, Secret: "0123456789abcdef"}
+	delivery.Event = models.OutboxEvent{ID: 5, Type: models.EventPurchaseCompleted, UserID: 3,
+		CreatedAt: time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC), Data: map[string]interface{}{"item": "cup"}}
+	return delivery
+}
+
+func TestWebhookSender(t *testing.T) {
+	now := time.Date(2025, 3, 3, 10, 0, 5, 0, time.UTC)
+	status := http.StatusNoContent
+	var received map[string]interface{}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// получатель проверяет подпись так же, как описано в README
+		expected := webhooks.Sign("0123456789abcdef", r.Header.Get(webhooks.TimestampHeader), body)
+		if r.Header.Get(webhooks.SignatureHeader) != expected {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &received)
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+	sender := webhooks.Sender{Client: receiver.Client(), Now: func() time.Time { return now }}
+
+	t.Run("Доставка с верной подписью", func(t *testing.T) {
+		delivery := webhookDelivery(receiver.URL)
+
+		code, err := sender.Send(&delivery)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, code)
+		assert.Equal(t, models.EventPurchaseCompleted, received["type"])
+		assert.Equal(t, float64(5), received["id"])
+		assert.Equal(t, "cup", received["data"].(map[string]interface{})["item"])
+	})
+
+	t.Run("Чужой секрет не проходит проверку получателя", func(t *testing.T) {
+		delivery := webhookDelivery(receiver.URL)
+		delivery.Subscription.Secret = "another-secret-value"
+
+		code, err := sender.Send(&delivery)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("Ошибка получателя возвращается со статусом", func(t *testing.T) {
+		status = http.StatusServiceUnavailable
+		delivery := webhookDelivery(receiver.URL)
+
+		code, err := sender.Send(&delivery)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+	})
+}
+
+func TestWebhookRetry(t *testing.T) {
+	retry := models.WebhookRetry{MaxAttempts: 3, Backoff: models.Backoff{Initial: 30 * time.Second, Max: time.Minute}}
+	now := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+
+	t.Run("Задержка удваивается до предела", func(t *testing.T) {
+		assert.Equal(t, 30*time.Second, retry.Delay(1))
+		assert.Equal(t, time.Minute, retry.Delay(2))
+		assert.Equal(t, time.Minute, retry.Delay(10))
+	})
+
+	t.Run("После последней попытки доставка уходит в dead letters", func(t *testing.T) {
+		delivery := models.WebhookDelivery{Status: models.DeliveryPending}
+
+		delivery.RecordAttempt(http.StatusBadGateway, errors.New("receiver answered 502"), now, retry)
+		assert.Equal(t, models.DeliveryPending, delivery.Status)
+		assert.Equal(t, now.Add(30*time.Second), delivery.NextAttemptAt)
+
+		delivery.RecordAttempt(0, errors.New("timeout"), now, retry)
+		delivery.RecordAttempt(0, errors.New("timeout"), now, retry)
+		assert.Equal(t, models.DeliveryDead, delivery.Status)
+		assert.Equal(t, 3, delivery.Attempts)
+		assert.Equal(t, "timeout", delivery.LastError)
+	})
+
+	t.Run("Успешная доставка", func(t *testing.T) {
+		delivery := models.WebhookDelivery{Status: models.DeliveryPending, LastError: "timeout"}
+
+		delivery.RecordAttempt(http.StatusOK, nil, now, retry)
+		assert.Equal(t, models.DeliveryDelivered, delivery.Status)
+		assert.Equal(t, &now, delivery.DeliveredAt)
+		assert.Empty(t, delivery.LastError)
+	})
+}
+
+func TestDeliverDueWebhook(t *testing.T) {
+	sqlDB, db, mock := DbMock(t)
+	defer sqlDB.Close()
+	database.PostgresDB = db
+	now := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	retry := models.WebhookRetry{MaxAttempts: 8, Lease: time.Minute, Backoff: models.Backoff{Initial: 30 * time.Second, Max: time.Hour}}
+
+	t.Run("Неудачная попытка переносится на потом", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT \* FROM "webhook_deliveries" WHERE status = \$1 AND next_attempt_at <= \$2 ORDER BY next_attempt_at LIMIT \$3 FOR UPDATE SKIP LOCKED`).
+			WithArgs(models.DeliveryPending, now, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "subscription_id", "event_id", "status", "attempts"}).
+				AddRow(11, 2, 5, models.DeliveryPending, 0))
+		mock.ExpectQuery(`SELECT \* FROM "outbox_events" WHERE "outbox_events"."id" = \$1`).
+			WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "type", "user_id"}).AddRow(5, models.EventCoinReceived, 3))
+		mock.ExpectQuery(`SELECT \* FROM "webhook_subscriptions" WHERE "webhook_subscriptions"."id" = \$1 AND "webhook_subscriptions"."deleted_at" IS NULL`).
+			WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "url", "secret"}).AddRow(2, "http://hooks.local", "secret"))
+		//доставка занимается на время отправки, транзакция завершается до запроса
+		mock.ExpectExec(`UPDATE "webhook_deliveries" SET "updated_at"=\$1,"next_attempt_at"=\$2 WHERE "id" = \$3`).
+			WithArgs(sqlmock.AnyArg(), now.Add(time.Minute), 11).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "webhook_deliveries" SET "updated_at"=\$1,"status"=\$2,"next_attempt_at"=\$3,"attempts"=\$4,"last_status"=\$5,"last_error"=\$6,"delivered_at"=\$7 WHERE "id" = \$8`).
+			WithArgs(sqlmock.AnyArg(), models.DeliveryPending, now.Add(30*time.Second), 1, http.StatusServiceUnavailable,
+				"receiver answered 503", nil, 11).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		found, err := models.DeliverDueWebhook(db, now, retry, func(delivery *models.WebhookDelivery) (int, error) {
+			assert.Equal(t, "http://hooks.local", delivery.Subscription.URL)
+			assert.Equal(t, models.EventCoinReceived, delivery.Event.Type)
+			return http.StatusServiceUnavailable, errors.New("receiver answered 503")
+		})
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
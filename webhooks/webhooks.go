@@ -0,0 +1,127 @@
+package webhooks
+
+import (
+	"avito/config"
+	"avito/database"
+	"avito/models"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Shop-Signature"
+	TimestampHeader = "X-Shop-Timestamp"
+	EventHeader     = "X-Shop-Event"
+	DeliveryHeader  = "X-Shop-Delivery"
+)
+
+const enqueueBatch = 100
+
+// Sign returns the signature of a delivery: HMAC-SHA256 of the timestamp, a
+// dot and the body keyed by the subscription secret. Receivers recompute it
+// and reject old timestamps to stop replays.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type payload struct {
+	ID         uint                   `json:"id"`
+	Type       string                 `json:"type"`
+	UserID     uint                   `json:"user_id"`
+	OccurredAt time.Time              `json:"occurred_at"`
+	Data       map[string]interface{} `json:"data"`
+}
+
+// Sender posts deliveries to the subscribers. Any 2xx answer is a success.
+type Sender struct {
+	Client *http.Client
+	Now    func() time.Time
+}
+
+func (sender Sender) Send(delivery *models.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(payload{
+		ID:         delivery.Event.ID,
+		Type:       delivery.Event.Type,
+		UserID:     delivery.Event.UserID,
+		OccurredAt: delivery.Event.CreatedAt,
+		Data:       delivery.Event.Data,
+	})
+	if err != nil {
+		return 0, err
+	}
+	request, err := http.NewRequest(http.MethodPost, delivery.Subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(sender.Now().Unix(), 10)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(TimestampHeader, timestamp)
+	request.Header.Set(SignatureHeader, Sign(delivery.Subscription.Secret, timestamp, body))
+	request.Header.Set(EventHeader, delivery.Event.Type)
+	request.Header.Set(DeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+
+	response, err := sender.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		answer, _ := io.ReadAll(io.LimitReader(response.Body, 200))
+		return response.StatusCode, fmt.Errorf("receiver answered %d: %s", response.StatusCode, answer)
+	}
+	return response.StatusCode, nil
+}
+
+func Retry() models.WebhookRetry {
+	return models.WebhookRetry{
+		MaxAttempts: config.Cfg.Webhooks.MaxAttempts,
+		Lease:       config.Cfg.Webhooks.Timeout + time.Minute,
+		Backoff:     models.Backoff{Initial: config.Cfg.Webhooks.Backoff, Max: 6 * time.Hour},
+	}
+}
+
+// RunDue hands new outbox events to the subscriptions and sends every
+// delivery that is due, returning how many were sent.
+func RunDue(sender Sender, now time.Time) (int, error) {
+	for {
+		taken, err := models.EnqueueWebhooks(database.PostgresDB, now, enqueueBatch)
+		if err != nil {
+			return 0, err
+		}
+		if taken < enqueueBatch {
+			break
+		}
+	}
+	count := 0
+	for {
+		found, err := models.DeliverDueWebhook(database.PostgresDB, now, Retry(), sender.Send)
+		if err != nil || !found {
+			return count, err
+		}
+		count++
+	}
+}
+
+func Run(interval time.Duration) {
+	sender := Sender{Client: &http.Client{Timeout: config.Cfg.Webhooks.Timeout}, Now: time.Now}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := RunDue(sender, time.Now()); err != nil {
+			log.Printf("[webhooks] %v", err)
+		}
+	}
+}